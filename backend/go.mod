@@ -6,6 +6,7 @@ toolchain go1.23.11
 
 require (
 	github.com/golang-jwt/jwt v3.2.2+incompatible
+	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/mattn/go-sqlite3 v1.14.28
 	github.com/rubenv/sql-migrate v1.8.0
@@ -14,5 +15,5 @@ require (
 
 require (
 	github.com/go-gorp/gorp/v3 v3.1.0 // indirect
-	github.com/google/uuid v1.6.0 // indirect
+	golang.org/x/sys v0.34.0 // indirect
 )
@@ -0,0 +1,3 @@
+-- +migrate Down
+DROP INDEX IF EXISTS idx_token_expires_at;
+DROP INDEX IF EXISTS idx_token_token;
@@ -0,0 +1,3 @@
+-- +migrate Up
+CREATE UNIQUE INDEX IF NOT EXISTS idx_token_token ON token(token);
+CREATE INDEX IF NOT EXISTS idx_token_expires_at ON token(expires_at);
@@ -0,0 +1,4 @@
+-- +migrate Down
+ALTER TABLE token DROP COLUMN last_seen_at;
+ALTER TABLE token DROP COLUMN ip_address;
+ALTER TABLE token DROP COLUMN user_agent;
@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE token ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE token ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE token ADD COLUMN last_seen_at DATETIME DEFAULT NULL;
@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME DEFAULT NULL;
+ALTER TABLE users ADD COLUMN verification_sent_at DATETIME DEFAULT NULL;
+
+-- accounts created before verification existed are trusted as they are
+UPDATE users SET email_verified_at = CURRENT_TIMESTAMP;
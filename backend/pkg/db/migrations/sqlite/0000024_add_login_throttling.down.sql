@@ -0,0 +1,4 @@
+-- +migrate Down
+ALTER TABLE users DROP COLUMN is_admin;
+DROP TABLE IF EXISTS account_lockouts;
+DROP TABLE IF EXISTS login_throttles;
@@ -0,0 +1,24 @@
+-- +migrate Up
+-- failed login counters, keyed by "user:<id>", "login:<identifier>" or "ip:<address>"
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at DATETIME NOT NULL,
+    locked_until DATETIME DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS account_lockouts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    ip_address TEXT NOT NULL DEFAULT '',
+    failures INTEGER NOT NULL,
+    locked_until DATETIME NOT NULL,
+    unlocked_at DATETIME DEFAULT NULL,
+    unlocked_by INTEGER DEFAULT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_lockouts_user_id ON account_lockouts(user_id);
+
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
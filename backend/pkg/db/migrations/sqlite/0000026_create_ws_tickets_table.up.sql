@@ -0,0 +1,10 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS ws_tickets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    ticket_hash TEXT NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL,
+    session_id TEXT NOT NULL DEFAULT '',
+    api_token_id INTEGER NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,28 @@
+-- +migrate Down
+-- only 1:1 chats can be turned back into sender/receiver rows
+CREATE TABLE messages_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender_id INTEGER NOT NULL,
+    receiver_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO messages_old (id, sender_id, receiver_id, content, timestamp)
+SELECT m.id, m.sender_id, COALESCE(
+        (SELECT cm.user_id FROM chat_members AS cm WHERE cm.chat_id = m.chat_id AND cm.user_id != m.sender_id),
+        m.sender_id),
+    m.content, m.created_at
+FROM messages AS m
+JOIN chats AS c ON c.id = m.chat_id
+WHERE c.direct_key IS NOT NULL;
+
+DROP TABLE messages;
+ALTER TABLE messages_old RENAME TO messages;
+
+DELETE FROM chat_members WHERE chat_id IN (SELECT id FROM chats WHERE direct_key IS NOT NULL OR created_by IS NOT NULL);
+DELETE FROM chats WHERE direct_key IS NOT NULL OR created_by IS NOT NULL;
+DROP INDEX IF EXISTS idx_chat_members_user_id;
+DROP INDEX IF EXISTS idx_chats_direct_key;
+ALTER TABLE chats DROP COLUMN created_by;
+ALTER TABLE chats DROP COLUMN direct_key;
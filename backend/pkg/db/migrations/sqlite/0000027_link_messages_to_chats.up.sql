@@ -0,0 +1,39 @@
+-- +migrate Up
+-- a 1:1 chat has direct_key "<lowest user id>:<highest user id>", so each pair has a single one
+ALTER TABLE chats ADD COLUMN direct_key TEXT DEFAULT NULL;
+ALTER TABLE chats ADD COLUMN created_by INTEGER DEFAULT NULL REFERENCES users(id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chats_direct_key ON chats(direct_key);
+CREATE INDEX IF NOT EXISTS idx_chat_members_user_id ON chat_members(user_id);
+
+-- one chat per pair of users who already exchanged messages
+INSERT INTO chats (is_group, direct_key, created_at)
+SELECT 0, MIN(sender_id, receiver_id) || ':' || MAX(sender_id, receiver_id), MIN(timestamp)
+FROM messages
+GROUP BY MIN(sender_id, receiver_id), MAX(sender_id, receiver_id);
+
+INSERT OR IGNORE INTO chat_members (chat_id, user_id)
+SELECT c.id, m.sender_id FROM messages AS m
+JOIN chats AS c ON c.direct_key = MIN(m.sender_id, m.receiver_id) || ':' || MAX(m.sender_id, m.receiver_id);
+
+INSERT OR IGNORE INTO chat_members (chat_id, user_id)
+SELECT c.id, m.receiver_id FROM messages AS m
+JOIN chats AS c ON c.direct_key = MIN(m.sender_id, m.receiver_id) || ':' || MAX(m.sender_id, m.receiver_id);
+
+-- messages now belong to a chat instead of a receiver
+CREATE TABLE messages_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO messages_new (id, chat_id, sender_id, content, created_at)
+SELECT m.id, c.id, m.sender_id, m.content, m.timestamp FROM messages AS m
+JOIN chats AS c ON c.direct_key = MIN(m.sender_id, m.receiver_id) || ':' || MAX(m.sender_id, m.receiver_id);
+
+DROP TABLE messages;
+ALTER TABLE messages_new RENAME TO messages;
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id, id);
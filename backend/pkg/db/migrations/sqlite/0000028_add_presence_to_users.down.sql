@@ -0,0 +1,3 @@
+-- +migrate Down
+ALTER TABLE users DROP COLUMN last_seen_at;
+ALTER TABLE users DROP COLUMN hide_presence;
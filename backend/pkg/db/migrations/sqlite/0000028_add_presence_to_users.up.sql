@@ -0,0 +1,3 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN hide_presence BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN last_seen_at DATETIME DEFAULT NULL;
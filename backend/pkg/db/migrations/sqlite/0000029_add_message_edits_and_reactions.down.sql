@@ -0,0 +1,6 @@
+-- +migrate Down
+DROP TABLE IF EXISTS message_reactions;
+DROP TABLE IF EXISTS message_hidden;
+DROP TABLE IF EXISTS message_edits;
+ALTER TABLE messages DROP COLUMN deleted_at;
+ALTER TABLE messages DROP COLUMN edited_at;
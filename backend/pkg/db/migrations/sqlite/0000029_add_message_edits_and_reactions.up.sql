@@ -0,0 +1,39 @@
+-- +migrate Up
+-- a message deleted for everyone stays as a tombstone, its content emptied
+ALTER TABLE messages ADD COLUMN edited_at DATETIME DEFAULT NULL;
+ALTER TABLE messages ADD COLUMN deleted_at DATETIME DEFAULT NULL;
+
+-- previous versions of edited messages
+CREATE TABLE IF NOT EXISTS message_edits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    edited_at DATETIME NOT NULL,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_edits_message_id ON message_edits(message_id);
+
+-- messages a member deleted for themselves only
+CREATE TABLE IF NOT EXISTS message_hidden (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    hidden_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_hidden_user_id ON message_hidden(user_id);
+
+CREATE TABLE IF NOT EXISTS message_reactions (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id, emoji),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_reactions_user_id ON message_reactions(user_id);
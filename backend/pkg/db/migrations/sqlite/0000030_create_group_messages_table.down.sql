@@ -0,0 +1,3 @@
+-- +migrate Down
+ALTER TABLE groups DROP COLUMN message_retention_days;
+DROP TABLE IF EXISTS group_messages;
@@ -0,0 +1,17 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS group_messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    group_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    sender TEXT NOT NULL DEFAULT '',
+    text TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_messages_group_id ON group_messages(group_id, id);
+CREATE INDEX IF NOT EXISTS idx_group_messages_sender_id ON group_messages(sender_id);
+
+-- NULL keeps the messages of a group forever
+ALTER TABLE groups ADD COLUMN message_retention_days INTEGER DEFAULT NULL;
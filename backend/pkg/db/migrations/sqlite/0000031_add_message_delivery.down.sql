@@ -0,0 +1,4 @@
+-- +migrate Down
+ALTER TABLE chat_members DROP COLUMN last_delivered_message_id;
+DROP INDEX IF EXISTS idx_messages_client_id;
+ALTER TABLE messages DROP COLUMN client_id;
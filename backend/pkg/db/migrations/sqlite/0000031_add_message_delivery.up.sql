@@ -0,0 +1,10 @@
+-- +migrate Up
+-- an ID picked by the sending client, so a retried send doesn't store the message twice
+ALTER TABLE messages ADD COLUMN client_id TEXT DEFAULT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_id ON messages(sender_id, client_id) WHERE client_id IS NOT NULL;
+
+-- the member's sockets received every message up to this one
+ALTER TABLE chat_members ADD COLUMN last_delivered_message_id INTEGER DEFAULT NULL;
+
+-- existing history isn't replayed to anyone
+UPDATE chat_members SET last_delivered_message_id = (SELECT MAX(id) FROM messages WHERE messages.chat_id = chat_members.chat_id);
@@ -0,0 +1,4 @@
+-- +migrate Down
+DROP TABLE IF EXISTS user_blocks;
+DROP INDEX IF EXISTS idx_chat_members_user_status;
+ALTER TABLE chat_members DROP COLUMN status;
@@ -0,0 +1,17 @@
+-- +migrate Up
+-- a member who can't be messaged directly by whoever added them gets the chat as a
+-- message request: 'pending' until they accept or decline it
+ALTER TABLE chat_members ADD COLUMN status TEXT NOT NULL DEFAULT 'accepted';
+
+CREATE INDEX IF NOT EXISTS idx_chat_members_user_status ON chat_members(user_id, status);
+
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id INTEGER NOT NULL,
+    blocked_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blocker_id, blocked_id),
+    FOREIGN KEY (blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked_id ON user_blocks(blocked_id);
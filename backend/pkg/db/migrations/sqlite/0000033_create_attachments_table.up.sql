@@ -0,0 +1,25 @@
+-- +migrate Up
+-- files uploaded for chat messages, kept outside of the public uploads directory.
+-- An attachment belongs to its uploader until it is sent with one message, either
+-- in a chat or in a group.
+CREATE TABLE IF NOT EXISTS attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uploader_id INTEGER NOT NULL,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    storage_key TEXT NOT NULL UNIQUE,
+    width INTEGER DEFAULT NULL,
+    height INTEGER DEFAULT NULL,
+    has_thumbnail BOOLEAN NOT NULL DEFAULT 0,
+    chat_message_id INTEGER DEFAULT NULL,
+    group_message_id INTEGER DEFAULT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (chat_message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (group_message_id) REFERENCES group_messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_chat_message_id ON attachments(chat_message_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_group_message_id ON attachments(group_message_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_uploader_id ON attachments(uploader_id);
@@ -0,0 +1,3 @@
+-- +migrate Down
+DROP INDEX IF EXISTS idx_notifications_receiver;
+ALTER TABLE notifications DROP COLUMN archived_at;
@@ -0,0 +1,4 @@
+-- +migrate Up
+-- archived notifications leave the inbox but are kept, and can be brought back
+ALTER TABLE notifications ADD COLUMN archived_at DATETIME DEFAULT NULL;
+CREATE INDEX IF NOT EXISTS idx_notifications_receiver ON notifications(recever_id, id);
@@ -0,0 +1,6 @@
+-- +migrate Down
+DROP TABLE IF EXISTS group_message_reactions;
+DROP TABLE IF EXISTS group_message_hidden;
+DROP TABLE IF EXISTS group_message_edits;
+ALTER TABLE group_messages DROP COLUMN deleted_at;
+ALTER TABLE group_messages DROP COLUMN edited_at;
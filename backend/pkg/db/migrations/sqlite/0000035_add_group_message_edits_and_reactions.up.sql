@@ -0,0 +1,37 @@
+-- +migrate Up
+-- group messages get the same edits, tombstones, delete-for-me and reactions as chat messages
+ALTER TABLE group_messages ADD COLUMN edited_at DATETIME DEFAULT NULL;
+ALTER TABLE group_messages ADD COLUMN deleted_at DATETIME DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS group_message_edits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    edited_at DATETIME NOT NULL,
+    FOREIGN KEY (message_id) REFERENCES group_messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_message_edits_message_id ON group_message_edits(message_id);
+
+CREATE TABLE IF NOT EXISTS group_message_hidden (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    hidden_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id),
+    FOREIGN KEY (message_id) REFERENCES group_messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_message_hidden_user_id ON group_message_hidden(user_id);
+
+CREATE TABLE IF NOT EXISTS group_message_reactions (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    emoji TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id, emoji),
+    FOREIGN KEY (message_id) REFERENCES group_messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_message_reactions_user_id ON group_message_reactions(user_id);
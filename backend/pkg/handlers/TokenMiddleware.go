@@ -2,7 +2,12 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+
+	"social-network/pkg/models"
 	"social-network/pkg/tools"
 )
 
@@ -13,14 +18,10 @@ type ErrorMiddlewareResponse struct {
 func TokenMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		token := r.Header.Get("Authorization")
-		id, err := tools.CheckIsTokenValid(token)
-		if err != nil {
-			tools.ErrorJSONResponse(w, http.StatusUnauthorized, err.Error())
+		ctx, ok := authenticate(w, r)
+		if !ok {
 			return
 		}
-
-		ctx := context.WithValue(r.Context(), "userID", id)
 		next(w, r.WithContext(ctx))
 	}
 }
@@ -28,14 +29,110 @@ func TokenMiddleware(next http.HandlerFunc) http.HandlerFunc {
 func TokenMiddlewareHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		token := r.Header.Get("Authorization")
-		id, err := tools.CheckIsTokenValid(token)
-		if err != nil {
-			tools.ErrorJSONResponse(w, http.StatusUnauthorized, err.Error())
+		ctx, ok := authenticate(w, r)
+		if !ok {
 			return
 		}
-
-		ctx := context.WithValue(r.Context(), "userID", id)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// authenticate accepts a session access token or a personal access token. Sessions
+// can do anything, personal access tokens only what their scopes allow on the route.
+func authenticate(w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	header := r.Header.Get("Authorization")
+	if bearer := strings.TrimPrefix(header, "Bearer "); tools.IsAPIToken(bearer) {
+		apiToken, err := tools.CheckAPIToken(bearer)
+		if err != nil {
+			if !errors.Is(err, tools.ErrInvalidAPIToken) {
+				fmt.Println(err)
+				tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+				return nil, false
+			}
+			unauthorized(w, err)
+			return nil, false
+		}
+		if !checkScope(w, r, apiToken) {
+			return nil, false
+		}
+
+		ctx := context.WithValue(r.Context(), "userID", apiToken.UserID)
+		ctx = context.WithValue(ctx, "tokenID", "")
+		ctx = context.WithValue(ctx, "apiTokenID", apiToken.ID)
+		return ctx, true
+	}
+
+	claims, err := tools.CheckTokenClaims(header)
+	if err != nil {
+		unauthorized(w, err)
+		return nil, false
+	}
+
+	ctx := context.WithValue(r.Context(), "userID", claims.UserID)
+	ctx = context.WithValue(ctx, "tokenID", claims.TokenID)
+	return ctx, true
+}
+
+// an expired access token gets its own code so clients know to refresh silently
+func unauthorized(w http.ResponseWriter, err error) {
+	if errors.Is(err, tools.ErrTokenExpired) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="token expired"`)
+		tools.ErrorCodeJSONResponse(w, http.StatusUnauthorized, "token_expired", err.Error())
+		return
+	}
+	tools.ErrorCodeJSONResponse(w, http.StatusUnauthorized, "invalid_token", err.Error())
+}
+
+// VerifiedMiddleware lets only users with a verified email change anything,
+// reads stay open. It goes inside TokenMiddleware, which sets the user ID.
+func VerifiedMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requireVerified(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+func VerifiedMiddlewareHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requireVerified(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func requireVerified(w http.ResponseWriter, r *http.Request) bool {
+	if isSafeMethod(r.Method) {
+		return true
+	}
+	userID, _ := r.Context().Value("userID").(int)
+	verified, err := models.Db.IsEmailVerified(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return false
+	}
+	if !verified {
+		tools.ErrorCodeJSONResponse(w, http.StatusForbidden, "email_not_verified", "please verify your email address first")
+		return false
+	}
+	return true
+}
+
+// AdminMiddleware restricts a route to admins. It goes inside TokenMiddleware.
+func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value("userID").(int)
+		isAdmin, err := models.Db.IsAdmin(userID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if !isAdmin {
+			tools.ErrorJSONResponse(w, http.StatusForbidden, "admins only")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type AccountResponse struct {
+	Message string `json:"message"`
+}
+
+// ChangePassword sets a new password for the current user, every other session and every personal access token is revoked
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	tokenID, _ := r.Context().Value("tokenID").(string)
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "please fill all the fields")
+		return
+	}
+
+	user, err := models.Db.GetUserByID(userID)
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+	_, ip := tools.ClientInfo(r)
+	ok, err := tools.CheckAccountPassword(user, req.CurrentPassword, ip)
+	if err != nil {
+		var locked *tools.LoginLockedError
+		if errors.As(err, &locked) {
+			loginLocked(w, locked)
+			return
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !ok {
+		tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, "invalid_password", "current password is incorrect")
+		return
+	}
+	if !tools.IsValidPassword(req.NewPassword) {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, tools.ErrInvalidPassword.Error())
+		return
+	}
+
+	if err := models.Db.UpdatePasswordHash(userID, req.NewPassword); err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	revoked, err := models.Db.DeleteUserTokensExcept(userID, tokenID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	for _, sessionID := range revoked {
+		disconnectSession(sessionID)
+	}
+	// a leaked personal access token must not outlive the password either
+	apiTokens, err := models.Db.DeleteUserAPITokens(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	for _, id := range apiTokens {
+		disconnectSession(tools.APITokenSessionID(id))
+	}
+
+	tools.JSONResponse(w, http.StatusOK, AccountResponse{Message: "password changed"})
+}
+
+// ForgotPassword emails a reset link, it answers the same whether the email is known or not.
+// The email is sent in the background so a known address doesn't answer slower.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	_, ip := tools.ClientInfo(r)
+	until, err := tools.AllowPasswordReset(req.Email, ip)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if until != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*until).Seconds())+1))
+		tools.ErrorCodeJSONResponse(w, http.StatusTooManyRequests, "rate_limited", "too many password reset requests, please wait before asking again")
+		return
+	}
+
+	go func(email string) {
+		if err := tools.RequestPasswordReset(email); err != nil {
+			fmt.Println("failed to send password reset:", err)
+		}
+	}(req.Email)
+
+	tools.JSONResponse(w, http.StatusOK, AccountResponse{
+		Message: "if an account uses this email, a reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password from a reset token, every session of the user is logged out
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "please fill all the fields")
+		return
+	}
+	if !tools.IsValidPassword(req.Password) {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, tools.ErrInvalidPassword.Error())
+		return
+	}
+
+	revoked, err := tools.ResetPassword(req.Token, req.Password)
+	if err != nil {
+		if errors.Is(err, tools.ErrInvalidResetToken) {
+			tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, "invalid_reset_token", err.Error())
+			return
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	for _, sessionID := range revoked {
+		disconnectSession(sessionID)
+	}
+
+	tools.JSONResponse(w, http.StatusOK, AccountResponse{Message: "password updated, you can now log in"})
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyEmail confirms the email address of an account from the link sent at registration
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "verification token is required")
+		return
+	}
+
+	if err := tools.VerifyEmail(req.Token); err != nil {
+		if errors.Is(err, tools.ErrInvalidVerificationToken) {
+			tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, "invalid_verification_token", err.Error())
+			return
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, AccountResponse{Message: "email verified"})
+}
+
+// ResendVerification sends a new verification link to the current user
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	err := tools.SendVerificationEmail(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, tools.ErrAlreadyVerified):
+			tools.ErrorCodeJSONResponse(w, http.StatusConflict, "already_verified", err.Error())
+		case errors.Is(err, tools.ErrVerificationRateLimited):
+			if sentAt, err := models.Db.GetVerificationSentAt(userID); err == nil && sentAt != nil {
+				wait := time.Until(sentAt.Add(tools.VerificationResendInterval))
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			}
+			tools.ErrorCodeJSONResponse(w, http.StatusTooManyRequests, "rate_limited", err.Error())
+		default:
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, AccountResponse{Message: "verification email sent"})
+}
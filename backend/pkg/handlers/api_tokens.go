@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type APITokenResponse struct {
+	Message string           `json:"message"`
+	Token   string           `json:"token"`
+	Details *models.APIToken `json:"details"`
+}
+
+type APITokensResponse struct {
+	Tokens []models.APIToken `json:"tokens"`
+	Scopes map[string]string `json:"available_scopes"`
+}
+
+// APITokensHandler lists the personal access tokens of the current user (GET)
+// or creates one (POST), the token itself is only shown in that response
+func APITokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := models.Db.GetUserAPITokens(userID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if tokens == nil {
+			tokens = []models.APIToken{}
+		}
+		tools.JSONResponse(w, http.StatusOK, APITokensResponse{Tokens: tokens, Scopes: tools.APIScopes})
+
+	case http.MethodPost:
+		var req CreateAPITokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if len(req.Scopes) == 0 {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "at least one scope is required")
+			return
+		}
+
+		token, stored, err := tools.CreateAPIToken(userID, req.Name, req.Scopes, req.ExpiresAt)
+		if err != nil {
+			var scopeErr *tools.ScopeError
+			switch {
+			case errors.As(err, &scopeErr):
+				tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, "invalid_scope", err.Error())
+			case errors.Is(err, tools.ErrInvalidTokenName), errors.Is(err, tools.ErrTokenExpiryPassed):
+				tools.ErrorJSONResponse(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, tools.ErrTooManyAPITokens):
+				tools.ErrorJSONResponse(w, http.StatusConflict, err.Error())
+			default:
+				fmt.Println(err)
+				tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			}
+			return
+		}
+
+		tools.JSONResponse(w, http.StatusCreated, APITokenResponse{
+			Message: "token created, copy it now, it won't be shown again",
+			Token:   token,
+			Details: stored,
+		})
+
+	default:
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// APITokenHandler revokes one personal access token of the current user
+func APITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected token id")
+		return
+	}
+
+	deleted, err := models.Db.DeleteAPIToken(userID, id)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !deleted {
+		tools.ErrorJSONResponse(w, http.StatusNotFound, "token not found")
+		return
+	}
+	disconnectSession(tools.APITokenSessionID(id))
+
+	tools.JSONResponse(w, http.StatusOK, AccountResponse{Message: "token revoked"})
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+const (
+	// attachments a single message can carry
+	maxMessageAttachments = 10
+	maxFileNameLength     = 255
+)
+
+var errAttachmentNotAvailable = &topicError{Code: "invalid_attachment", Message: "attachments must be your own uploads, not sent with another message"}
+
+// checkAttachmentIDs refuses too many or repeated attachments on a message
+func checkAttachmentIDs(ids []int) *topicError {
+	if len(ids) > maxMessageAttachments {
+		return &topicError{Code: "invalid_attachment", Message: fmt.Sprintf("a message can carry at most %d attachments", maxMessageAttachments)}
+	}
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return &topicError{Code: "invalid_attachment", Message: "an attachment can only be sent once per message"}
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// cleanFileName keeps the base name of an uploaded file without control characters
+func cleanFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == "/" || name == "" {
+		name = "file"
+	}
+	if len(name) > maxFileNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:maxFileNameLength-len(ext)], "") + ext
+	}
+	return name
+}
+
+// UploadAttachmentHandler stores a file sent as the "file" field of a multipart form. The
+// upload can then be sent with one message through its ID, unsent uploads are deleted after a day
+// and a user can only keep tools.MaxUnsentAttachments of them, up to tools.MaxUnsentAttachmentBytes.
+func UploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	// the budget is checked before reading the body, and again with the size of the file
+	unsent, unsentBytes, err := models.Db.GetUnsentAttachmentUsage(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if unsent >= tools.MaxUnsentAttachments || unsentBytes >= tools.MaxUnsentAttachmentBytes {
+		tooManyUploads(w)
+		return
+	}
+
+	// room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, tools.MaxAttachmentSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			tools.ErrorCodeJSONResponse(w, http.StatusRequestEntityTooLarge, "file_too_large",
+				fmt.Sprintf("attachments must be at most %d MB", tools.MaxAttachmentSize>>20))
+			return
+		}
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "missing file")
+		return
+	}
+	defer file.Close()
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	if header.Size > tools.MaxAttachmentSize {
+		tools.ErrorCodeJSONResponse(w, http.StatusRequestEntityTooLarge, "file_too_large",
+			fmt.Sprintf("attachments must be at most %d MB", tools.MaxAttachmentSize>>20))
+		return
+	}
+	if header.Size == 0 {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "the file is empty")
+		return
+	}
+	if unsentBytes+header.Size > tools.MaxUnsentAttachmentBytes {
+		tooManyUploads(w)
+		return
+	}
+
+	// the type is sniffed from the content, the name and headers sent by the client aren't trusted
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "failed to read the file")
+		return
+	}
+	contentType := http.DetectContentType(sniff[:n])
+	if !tools.AttachmentTypes[contentType] {
+		tools.ErrorCodeJSONResponse(w, http.StatusUnsupportedMediaType, "unsupported_type",
+			"attachments must be images, PDF, plain text, zip archives, audio or video")
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	attachment := &models.Attachment{
+		UploaderID:  userID,
+		FileName:    cleanFileName(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+		StorageKey:  uuid.NewString(),
+	}
+	if err := tools.SaveAttachment(file, attachment.StorageKey); err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if strings.HasPrefix(contentType, "image/") {
+		width, height, ok, err := tools.MakeThumbnail(attachment.StorageKey)
+		if err != nil {
+			// the file is still usable without a thumbnail
+			fmt.Println(err)
+		}
+		if width > 0 {
+			attachment.Width, attachment.Height = &width, &height
+		}
+		attachment.HasThumbnail = ok
+	}
+
+	if err := models.Db.InsertAttachment(attachment); err != nil {
+		tools.RemoveAttachmentFiles(attachment.StorageKey)
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	tools.JSONResponse(w, http.StatusCreated, attachment)
+}
+
+func tooManyUploads(w http.ResponseWriter) {
+	tools.ErrorCodeJSONResponse(w, http.StatusTooManyRequests, "too_many_uploads",
+		fmt.Sprintf("too many uploads waiting to be sent, send them first or let them expire: at most %d and %d MB",
+			tools.MaxUnsentAttachments, tools.MaxUnsentAttachmentBytes>>20))
+}
+
+// AttachmentHandler downloads an attachment, only its uploader and the members of the
+// conversation it was sent in can read it
+func AttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	serveAttachment(w, r, false)
+}
+
+// AttachmentThumbnailHandler returns the JPEG thumbnail of an image attachment
+func AttachmentThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	serveAttachment(w, r, true)
+}
+
+func serveAttachment(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	attachmentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected attachment id")
+		return
+	}
+
+	attachment, err := models.Db.GetAttachment(attachmentID, userID)
+	if err != nil {
+		if err.Error() == "attachment not found" {
+			tools.ErrorJSONResponse(w, http.StatusNotFound, "attachment not found")
+			return
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if thumbnail && !attachment.HasThumbnail {
+		tools.ErrorJSONResponse(w, http.StatusNotFound, "this attachment has no thumbnail")
+		return
+	}
+
+	path, contentType, disposition := tools.AttachmentPath(attachment.StorageKey), attachment.ContentType, "attachment"
+	if thumbnail {
+		path, contentType = tools.ThumbnailPath(attachment.StorageKey), "image/jpeg"
+	}
+	// images show in the page, anything else is saved so it never runs in our origin
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", attachment.CreatedAt, f)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+// refused when one of two users blocked the other, without telling who did
+var errBlocked = &topicError{Code: "blocked", Message: "you can't message this user"}
+
+// isMessageRequest tells whether a message from sender reaches target as a message request,
+// because target doesn't have a public profile and neither of them follows the other.
+// Blocked users can't message each other at all.
+func isMessageRequest(senderID, targetID int) (bool, error) {
+	blocked, err := models.Db.IsBlockedBetween(senderID, targetID)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, errBlocked
+	}
+	allowed, err := models.Db.CanMessageDirectly(senderID, targetID)
+	return !allowed, err
+}
+
+// messageRequests finds the users who get a thread as a message request when someone adds them
+func messageRequests(userID int, others []int) (map[int]bool, error) {
+	requests := make(map[int]bool)
+	for _, otherID := range others {
+		request, err := isMessageRequest(userID, otherID)
+		if err != nil {
+			return nil, err
+		}
+		requests[otherID] = request
+	}
+	return requests, nil
+}
+
+// checkNoBlockedMembers refuses to put users in a thread when one of them blocked one of its
+// members or another of them, or was blocked by them, since they would share the thread
+func checkNoBlockedMembers(members, added []int) error {
+	blocked, err := models.Db.IsBlockedWithAny(added, append(append([]int{}, members...), added...))
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return errBlocked
+	}
+	return nil
+}
+
+// checkNotBlocked refuses to write, react or type in a chat, 1:1 or thread, when one of its
+// members blocked the user or was blocked by them
+func checkNotBlocked(chatID, userID int) error {
+	blocked, err := models.Db.GetBlockedChatMembers(chatID, userID)
+	if err != nil {
+		return err
+	}
+	if len(blocked) > 0 {
+		return errBlocked
+	}
+	return nil
+}
+
+// checkChatNotBlocked answers 403 "blocked" when checkNotBlocked refuses the user, and 500 when it fails
+func checkChatNotBlocked(w http.ResponseWriter, chatID, userID int) bool {
+	if err := checkNotBlocked(chatID, userID); err != nil {
+		if err == errBlocked {
+			tools.ErrorCodeJSONResponse(w, http.StatusForbidden, errBlocked.Code, errBlocked.Message)
+			return false
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return false
+	}
+	return true
+}
+
+// BlocksHandler lists the users the current user blocked
+func BlocksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	blocked, err := models.Db.GetBlockedUsers(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	tools.JSONResponse(w, http.StatusOK, blocked)
+}
+
+// BlockHandler blocks (PUT) or unblocks (DELETE) a user. Blocked users can't message each
+// other or follow each other, and blocking drops the follows between them.
+func BlockHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(int)
+
+	blockedID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected user id")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if blockedID == userID {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "you can't block yourself")
+			return
+		}
+		if _, err := models.Db.GetUserByID(blockedID); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err := models.Db.BlockUser(userID, blockedID); err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		removed, err := models.Db.UnblockUser(userID, blockedID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if !removed {
+			tools.ErrorJSONResponse(w, http.StatusNotFound, "this user isn't blocked")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+const (
+	// distinct emojis a message can carry
+	maxMessageEmojis = 20
+	maxEmojiLength   = 32
+)
+
+// hiddenMessageEvent tells the other devices of a user that they deleted a message for themselves
+type hiddenMessageEvent struct {
+	ChatID    int `json:"chat_id,omitempty"`
+	GroupID   int `json:"group_id,omitempty"`
+	MessageID int `json:"message_id"`
+}
+
+// ChatMessageHandler edits (PATCH) or deletes (DELETE) a message. Only its sender may edit
+// it, within tools.MessageEditWindow. ?for=everyone leaves a tombstone for every member and
+// is also reserved to the sender, anyone can delete a message for themselves.
+func ChatMessageHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, msg, ok := messageFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case http.MethodPatch:
+		var req ChatMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if msg.SenderID != userID {
+			tools.ErrorJSONResponse(w, http.StatusForbidden, "you can only edit your own messages")
+			return
+		}
+		if msg.Deleted {
+			tools.ErrorJSONResponse(w, http.StatusConflict, "this message was deleted")
+			return
+		}
+		if !canEditMessage(msg.CreatedAt) {
+			tools.ErrorCodeJSONResponse(w, http.StatusForbidden, "edit_window_passed",
+				fmt.Sprintf("messages can only be edited for %s after being sent", tools.MessageEditWindow))
+			return
+		}
+		if err := checkMessageContent(req.Content, len(msg.Attachments)); err != nil {
+			tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, err.Code, err.Message)
+			return
+		}
+		if !checkChatNotBlocked(w, chatID, userID) {
+			return
+		}
+
+		if req.Content != msg.Content {
+			if err := models.Db.EditChatMessage(msg.ID, req.Content); err != nil {
+				fmt.Println(err)
+				tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			msg = publishMessageUpdate(w, chatID, msg.ID)
+			if msg == nil {
+				return
+			}
+		}
+		tools.JSONResponse(w, http.StatusOK, msg)
+
+	case http.MethodDelete:
+		switch r.URL.Query().Get("for") {
+		case "", "me":
+			if err := models.Db.HideChatMessage(msg.ID, userID); err != nil {
+				fmt.Println(err)
+				tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			if wsGateway != nil {
+				wsGateway.PublishToUser(userID, topicDirect, "message_hidden", hiddenMessageEvent{ChatID: chatID, MessageID: msg.ID})
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case "everyone":
+			if msg.SenderID != userID {
+				tools.ErrorJSONResponse(w, http.StatusForbidden, "you can only delete your own messages for everyone")
+				return
+			}
+			if !msg.Deleted {
+				if err := models.Db.DeleteChatMessage(msg.ID); err != nil {
+					fmt.Println(err)
+					tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+					return
+				}
+				msg = publishMessageUpdate(w, chatID, msg.ID)
+				if msg == nil {
+					return
+				}
+			}
+			tools.JSONResponse(w, http.StatusOK, msg)
+
+		default:
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "for must be me or everyone")
+		}
+
+	default:
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// ChatMessageEditsHandler returns the previous versions of a message, oldest first
+func ChatMessageEditsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	_, msg, ok := messageFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	edits, err := models.Db.GetChatMessageEdits(msg.ID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	tools.JSONResponse(w, http.StatusOK, edits)
+}
+
+// MessageReactionHandler adds (PUT) or removes (DELETE) the current user's reaction with an emoji
+func MessageReactionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	chatID, msg, ok := messageFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	emoji := r.PathValue("emoji")
+	if !isEmoji(emoji) {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected an emoji")
+		return
+	}
+	if msg.Deleted {
+		tools.ErrorJSONResponse(w, http.StatusConflict, "this message was deleted")
+		return
+	}
+	if !checkChatNotBlocked(w, chatID, userID) {
+		return
+	}
+
+	var changed bool
+	var err error
+	if r.Method == http.MethodPut {
+		changed, err = addReaction(msg, userID, emoji)
+	} else {
+		changed, err = models.Db.RemoveMessageReaction(msg.ID, userID, emoji)
+	}
+	if err != nil {
+		if refused, ok := err.(*topicError); ok {
+			tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, refused.Code, refused.Message)
+			return
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if changed {
+		msg = publishMessageUpdate(w, chatID, msg.ID)
+		if msg == nil {
+			return
+		}
+	}
+	tools.JSONResponse(w, http.StatusOK, msg)
+}
+
+// messageFromRequest loads the message of the path, answering 404 unless it belongs to a chat of the current user
+func messageFromRequest(w http.ResponseWriter, r *http.Request) (int, *models.ChatMessage, bool) {
+	chatID, ok := chatFromRequest(w, r)
+	if !ok {
+		return 0, nil, false
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("messageID"))
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected message id")
+		return 0, nil, false
+	}
+
+	msg, err := models.Db.GetChatMessage(chatID, messageID)
+	if err != nil {
+		if err.Error() == "message not found" {
+			tools.ErrorJSONResponse(w, http.StatusNotFound, "message not found")
+			return 0, nil, false
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return 0, nil, false
+	}
+	return chatID, msg, true
+}
+
+func canEditMessage(sentAt time.Time) bool {
+	return time.Since(sentAt) <= tools.MessageEditWindow
+}
+
+// isEmoji accepts a short run of symbols, plain text can't be used as a reaction
+func isEmoji(s string) bool {
+	if s == "" || len(s) > maxEmojiLength || !utf8.ValidString(s) {
+		return false
+	}
+	symbol := false
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsLetter(r) || unicode.IsControl(r) {
+			return false
+		}
+		if unicode.Is(unicode.So, r) {
+			symbol = true
+		}
+	}
+	return symbol
+}
+
+func addReaction(msg *models.ChatMessage, userID int, emoji string) (bool, error) {
+	if err := checkReactionLimit(msg.Reactions, emoji); err != nil {
+		return false, err
+	}
+	return models.Db.AddMessageReaction(msg.ID, userID, emoji)
+}
+
+// checkReactionLimit refuses a new emoji on a message that already has maxMessageEmojis different ones
+func checkReactionLimit(reactions []models.MessageReaction, emoji string) error {
+	for _, reaction := range reactions {
+		if reaction.Emoji == emoji {
+			return nil
+		}
+	}
+	if len(reactions) >= maxMessageEmojis {
+		return &topicError{Code: "too_many_reactions", Message: fmt.Sprintf("a message can't have more than %d different reactions", maxMessageEmojis)}
+	}
+	return nil
+}
+
+// publishMessageUpdate reloads a changed message and pushes it to the members who still see it.
+// It answers 500 itself and returns nil when the message can't be loaded.
+func publishMessageUpdate(w http.ResponseWriter, chatID, messageID int) *models.ChatMessage {
+	msg, err := models.Db.GetChatMessage(chatID, messageID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return nil
+	}
+
+	if wsGateway != nil {
+		audience, err := models.Db.GetMessageAudience(chatID, messageID)
+		if err != nil {
+			fmt.Println(err)
+			return msg
+		}
+		for _, memberID := range audience {
+			wsGateway.PublishToUser(memberID, topicDirect, "message_updated", msg)
+		}
+	}
+	return msg
+}
@@ -0,0 +1,647 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+const (
+	maxMessageLength = 4000
+	maxChatMembers   = 50
+	maxChatNameLen   = 100
+	// messages returned when the client doesn't ask for a number
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 100
+)
+
+type CreateChatRequest struct {
+	UserIDs []int  `json:"user_ids"`
+	Name    string `json:"name"`
+}
+
+type ChatMembersRequest struct {
+	UserIDs []int `json:"user_ids"`
+}
+
+type ChatMessageRequest struct {
+	Content string `json:"content"`
+	// optional idempotency key, a retried request with the same one returns the stored message
+	ClientID      string `json:"client_id"`
+	AttachmentIDs []int  `json:"attachment_ids"`
+}
+
+type ChatReadRequest struct {
+	// 0 marks the whole chat as read
+	MessageID int `json:"message_id"`
+}
+
+type ChatReadResponse struct {
+	models.ReadReceipt
+	UnreadCount int `json:"unread_count"`
+}
+
+// ChatsHandler lists the conversations of the current user, latest activity first (GET),
+// or starts one (POST). A single user without a name opens the 1:1 chat with them.
+func ChatsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case http.MethodGet:
+		chats, err := models.Db.GetUserChats(userID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		tools.JSONResponse(w, http.StatusOK, chats)
+
+	case http.MethodPost:
+		var req CreateChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if utf8.RuneCountInString(req.Name) > maxChatNameLen {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("name must be at most %d characters", maxChatNameLen))
+			return
+		}
+		others, ok := checkChatUsers(w, userID, req.UserIDs)
+		if !ok {
+			return
+		}
+
+		requests, err := messageRequests(userID, others)
+		if err == nil {
+			err = checkNoBlockedMembers([]int{userID}, others)
+		}
+		if err != nil {
+			if refused, ok := err.(*topicError); ok {
+				tools.ErrorCodeJSONResponse(w, http.StatusForbidden, refused.Code, refused.Message)
+				return
+			}
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		status := http.StatusCreated
+		var chatID int
+		if len(others) == 1 && req.Name == "" {
+			status = http.StatusOK
+			chatID, err = models.Db.GetOrCreateDirectChat(userID, others[0], requests[others[0]])
+		} else {
+			chatID, err = models.Db.CreateGroupChat(userID, req.Name, others, requests)
+		}
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		chat, err := models.Db.GetChat(chatID, userID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		tools.JSONResponse(w, status, chat)
+
+	default:
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// ChatHandler returns one conversation of the current user
+func ChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	chatID, ok := chatFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	chat, err := models.Db.GetChat(chatID, userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	tools.JSONResponse(w, http.StatusOK, chat)
+}
+
+// ChatMessagesHandler returns a page of a conversation (GET) or sends a message (POST)
+func ChatMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, ok := chatFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case http.MethodGet:
+		q, ok := pageQueryFromRequest(w, r)
+		if !ok {
+			return
+		}
+
+		page, err := models.Db.GetChatMessages(chatID, userID, q)
+		if err != nil {
+			if err.Error() == "message not found" {
+				tools.ErrorJSONResponse(w, http.StatusNotFound, "message not found")
+				return
+			}
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		tools.JSONResponse(w, http.StatusOK, page)
+
+	case http.MethodPost:
+		var req ChatMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := checkMessageContent(req.Content, len(req.AttachmentIDs)); err != nil {
+			tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, err.Code, err.Message)
+			return
+		}
+		if err := checkAttachmentIDs(req.AttachmentIDs); err != nil {
+			tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, err.Code, err.Message)
+			return
+		}
+		if err := checkClientID(req.ClientID); err != nil {
+			tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, err.Code, err.Message)
+			return
+		}
+
+		msg, duplicate, err := sendChatMessage(chatID, userID, req.Content, req.ClientID, req.AttachmentIDs)
+		if err != nil {
+			if refused, ok := err.(*topicError); ok {
+				status := http.StatusConflict
+				switch refused {
+				case errBlocked:
+					status = http.StatusForbidden
+				case errAttachmentNotAvailable:
+					status = http.StatusBadRequest
+				}
+				tools.ErrorCodeJSONResponse(w, status, refused.Code, refused.Message)
+				return
+			}
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if duplicate {
+			tools.JSONResponse(w, http.StatusOK, msg)
+			return
+		}
+		tools.JSONResponse(w, http.StatusCreated, msg)
+
+	default:
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// ChatMembersHandler adds people to a multi-person thread, 1:1 chats stay between their two users
+func ChatMembersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	chatID, ok := chatFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	chat, err := models.Db.GetChat(chatID, userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !chat.IsGroup {
+		tools.ErrorJSONResponse(w, http.StatusConflict, "start a new thread to talk with more people")
+		return
+	}
+
+	var req ChatMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	others, ok := checkChatUsers(w, userID, req.UserIDs)
+	if !ok {
+		return
+	}
+	if len(chat.Members)+len(others) > maxChatMembers {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("a thread can't have more than %d members", maxChatMembers))
+		return
+	}
+
+	members := make([]int, 0, len(chat.Members))
+	for _, member := range chat.Members {
+		members = append(members, member.UserID)
+	}
+	requests, err := messageRequests(userID, others)
+	if err == nil {
+		err = checkNoBlockedMembers(members, others)
+	}
+	if err != nil {
+		if refused, ok := err.(*topicError); ok {
+			tools.ErrorCodeJSONResponse(w, http.StatusForbidden, refused.Code, refused.Message)
+			return
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if err := models.Db.AddChatMembers(chatID, others, requests); err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	chat, err = models.Db.GetChat(chatID, userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	tools.JSONResponse(w, http.StatusOK, chat)
+}
+
+// ChatReadHandler marks a chat as read up to a message, the other members get a receipt
+func ChatReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	chatID, ok := chatFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	var req ChatReadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	messageID := req.MessageID
+	if messageID == 0 {
+		last, err := models.Db.GetLastChatMessageID(chatID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		messageID = last
+	} else {
+		inChat, err := models.Db.IsChatMessage(chatID, messageID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if !inChat {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "message not found in this chat")
+			return
+		}
+	}
+
+	if messageID > 0 {
+		if err := markChatRead(chatID, userID, messageID); err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+
+	chat, err := models.Db.GetChat(chatID, userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	res := ChatReadResponse{
+		ReadReceipt: models.ReadReceipt{ChatID: chatID, UserID: userID},
+		UnreadCount: chat.UnreadCount,
+	}
+	for _, m := range chat.Members {
+		if m.UserID == userID && m.LastReadMessageID != nil {
+			res.LastReadMessageID = *m.LastReadMessageID
+		}
+	}
+	tools.JSONResponse(w, http.StatusOK, res)
+}
+
+// MessageRequestsHandler lists the chats the current user got as message requests,
+// from people who can't message them directly
+func MessageRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	chats, err := models.Db.GetMessageRequests(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	tools.JSONResponse(w, http.StatusOK, chats)
+}
+
+// AcceptMessageRequestHandler moves a message request to the current user's chats
+func AcceptMessageRequestHandler(w http.ResponseWriter, r *http.Request) {
+	answerMessageRequest(w, r, models.ChatMemberAccepted)
+}
+
+// DeclineMessageRequestHandler turns a message request down, the chat disappears for the
+// current user and its sender isn't told
+func DeclineMessageRequestHandler(w http.ResponseWriter, r *http.Request) {
+	answerMessageRequest(w, r, models.ChatMemberDeclined)
+}
+
+func answerMessageRequest(w http.ResponseWriter, r *http.Request, status string) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	chatID, ok := chatFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	chat, err := models.Db.GetChat(chatID, userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if chat.Status != models.ChatMemberPending {
+		tools.ErrorJSONResponse(w, http.StatusConflict, "this chat isn't a message request")
+		return
+	}
+
+	if err := models.Db.SetChatMemberStatus(chatID, userID, status); err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if status == models.ChatMemberDeclined {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	chat.Status = status
+	tools.JSONResponse(w, http.StatusOK, chat)
+}
+
+// UnreadMessagesHandler returns the number of unread messages over every chat, for the badge
+func UnreadMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	count, err := models.Db.GetUnreadMessagesCount(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	tools.JSONResponse(w, http.StatusOK, map[string]int{"unread_count": count})
+}
+
+// pageQueryFromRequest reads the ?before=, ?after= or ?around= message ID and ?limit= of a history request
+func pageQueryFromRequest(w http.ResponseWriter, r *http.Request) (models.PageQuery, bool) {
+	query := r.URL.Query()
+	q := models.PageQuery{Limit: defaultMessagesLimit}
+
+	cursors := 0
+	for name, target := range map[string]*int{"before": &q.Before, "after": &q.After, "around": &q.Around} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, name+" must be a message id")
+			return q, false
+		}
+		*target = id
+		cursors++
+	}
+	if cursors > 1 {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "use only one of before, after and around")
+		return q, false
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "limit must be a positive number")
+			return q, false
+		}
+		q.Limit = min(limit, maxMessagesLimit)
+	}
+	return q, true
+}
+
+// chatFromRequest reads the chat ID of the path, answering 404 to non-members
+func chatFromRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID := r.Context().Value("userID").(int)
+
+	chatID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected chat id")
+		return 0, false
+	}
+
+	isMember, err := models.Db.IsChatMember(chatID, userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return 0, false
+	}
+	if !isMember {
+		tools.ErrorJSONResponse(w, http.StatusNotFound, "chat not found")
+		return 0, false
+	}
+	return chatID, true
+}
+
+// checkChatUsers dedupes the users to add to a chat and makes sure they exist
+func checkChatUsers(w http.ResponseWriter, userID int, userIDs []int) ([]int, bool) {
+	seen := map[int]bool{userID: true}
+	var others []int
+	for _, id := range userIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, err := models.Db.GetUserByID(id); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("user %d not found", id))
+			return nil, false
+		}
+		others = append(others, id)
+	}
+	if len(others) == 0 {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "at least one other user is required")
+		return nil, false
+	}
+	if len(others)+1 > maxChatMembers {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("a thread can't have more than %d members", maxChatMembers))
+		return nil, false
+	}
+	return others, true
+}
+
+// checkMessageContent refuses empty and oversized messages, and control characters other
+// than line breaks and tabs. A message carrying attachments may come without text.
+func checkMessageContent(content string, attachments int) *topicError {
+	if strings.TrimSpace(content) == "" && attachments == 0 {
+		return &topicError{Code: "invalid_message", Message: "content is required"}
+	}
+	if utf8.RuneCountInString(content) > maxMessageLength {
+		return &topicError{Code: "invalid_message", Message: fmt.Sprintf("content must be at most %d characters", maxMessageLength)}
+	}
+	if strings.IndexFunc(content, isControlCharacter) >= 0 {
+		return &topicError{Code: "invalid_message", Message: "content can't contain control characters"}
+	}
+	return nil
+}
+
+// isControlCharacter tells the C0 control characters and DEL apart from line breaks and tabs,
+// search snippets use some of them as markers
+func isControlCharacter(r rune) bool {
+	return (r < 0x20 && r != '\n' && r != '\r' && r != '\t') || r == 0x7f
+}
+
+// sendChatMessage stores a message and pushes it to every member of the chat,
+// the sender included so their other devices stay in sync. Members who have the chat
+// as a message request get a "message_request" event instead, and those who declined
+// it get nothing. Writing in a chat accepts it for the sender. A retry with a client ID
+// already used by the sender returns the stored message with true and pushes nothing.
+func sendChatMessage(chatID, senderID int, content, clientID string, attachmentIDs []int) (*models.ChatMessage, bool, error) {
+	if err := checkNotBlocked(chatID, senderID); err != nil {
+		return nil, false, err
+	}
+	msg, duplicate, err := models.Db.InsertChatMessage(chatID, senderID, content, clientID, attachmentIDs)
+	if err != nil {
+		switch err.Error() {
+		case "client id already used":
+			return nil, false, &topicError{Code: "client_id_conflict", Message: "this client_id was already used in another chat"}
+		case "attachment not available":
+			return nil, false, errAttachmentNotAvailable
+		}
+		return nil, false, err
+	}
+	if duplicate {
+		return msg, true, nil
+	}
+	// the sender has obviously read the chat up to their own message
+	if _, err := models.Db.MarkChatRead(chatID, senderID, msg.ID); err != nil {
+		return nil, false, err
+	}
+
+	statuses, err := models.Db.GetChatMemberStatuses(chatID)
+	if err != nil {
+		return nil, false, err
+	}
+	if statuses[senderID] != models.ChatMemberAccepted {
+		if err := models.Db.SetChatMemberStatus(chatID, senderID, models.ChatMemberAccepted); err != nil {
+			return nil, false, err
+		}
+		statuses[senderID] = models.ChatMemberAccepted
+	}
+
+	if wsGateway != nil {
+		for memberID, status := range statuses {
+			switch status {
+			case models.ChatMemberAccepted:
+				wsGateway.deliverChatMessage(memberID, msg)
+			case models.ChatMemberPending:
+				wsGateway.PublishToUser(memberID, topicDirect, "message_request", msg)
+			}
+		}
+	}
+	return msg, false, nil
+}
+
+// markChatDelivered moves the delivery position of a member and, when it moved, pushes a
+// "delivered" receipt to every member of the chat, but those in a block with them
+func markChatDelivered(chatID, userID, messageID int) error {
+	moved, err := models.Db.MarkChatDelivered(chatID, userID, messageID)
+	if err != nil || !moved || wsGateway == nil {
+		return err
+	}
+
+	members, err := models.Db.GetChatMemberIDs(chatID)
+	if err != nil {
+		return err
+	}
+	blocked, err := models.Db.GetBlockedChatMembers(chatID, userID)
+	if err != nil {
+		return err
+	}
+	receipt := models.DeliveryReceipt{ChatID: chatID, UserID: userID, LastDeliveredMessageID: messageID}
+	for _, memberID := range members {
+		if blocked[memberID] {
+			continue
+		}
+		wsGateway.PublishToUser(memberID, topicDirect, "delivered", receipt)
+	}
+	return nil
+}
+
+// markChatRead moves the read position of a member and, when it moved, pushes the
+// receipt to every member of the chat, the reader's other devices included and those
+// in a block with them left out
+func markChatRead(chatID, userID, messageID int) error {
+	moved, err := models.Db.MarkChatRead(chatID, userID, messageID)
+	if err != nil || !moved || wsGateway == nil {
+		return err
+	}
+
+	members, err := models.Db.GetChatMemberIDs(chatID)
+	if err != nil {
+		return err
+	}
+	blocked, err := models.Db.GetBlockedChatMembers(chatID, userID)
+	if err != nil {
+		return err
+	}
+	receipt := models.ReadReceipt{ChatID: chatID, UserID: userID, LastReadMessageID: messageID}
+	for _, memberID := range members {
+		if blocked[memberID] {
+			continue
+		}
+		wsGateway.PublishToUser(memberID, topicDirect, "read", receipt)
+	}
+	return nil
+}
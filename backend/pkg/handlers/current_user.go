@@ -30,15 +30,16 @@ func CurrentUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userData := map[string]interface{}{
-		"id":            user.ID,
-		"first_name":    user.Firstname,
-		"last_name":     user.Lastname,
-		"nickname":      user.Nickname.String,
-		"email":         user.Email,
-		"date_of_birth": user.DateOfBirth,
-		"about_me":      user.AboutMe,
-		"avatar":        user.Avatar,
-		"is_public":     user.IsPublic,
+		"id":             user.ID,
+		"first_name":     user.Firstname,
+		"last_name":      user.Lastname,
+		"nickname":       user.Nickname.String,
+		"email":          user.Email,
+		"date_of_birth":  user.DateOfBirth,
+		"about_me":       user.AboutMe,
+		"avatar":         user.Avatar,
+		"is_public":      user.IsPublic,
+		"email_verified": user.EmailVerified,
 	}
 
 	json.NewEncoder(w).Encode(userData)
@@ -27,6 +27,16 @@ func FollowUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	blocked, err := models.Db.IsBlockedBetween(followerID, followingID)
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if blocked {
+		tools.ErrorJSONResponse(w, http.StatusForbidden, "you can't follow this user")
+		return
+	}
+
 	isPublic, err := models.Db.CheckIfPublicProfil(followingID)
 	if err != nil {
 		if err.Error() == "this user not exist" {
@@ -0,0 +1,690 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"social-network/pkg/models"
+)
+
+// Envelope is every frame exchanged on /ws, in both directions.
+//
+// Clients send "subscribe" and "unsubscribe" with a topic, "send" with a topic and
+// data, "typing" with a topic, "presence" with a status, and "ping". The server answers
+// "subscribed", "unsubscribed", "ack" with the stored message, "pong" or "error",
+// echoing the id of the frame, and pushes "event" frames on subscribed
+// topics. "logout" is sent right before a revoked session is disconnected.
+type Envelope struct {
+	Type  string          `json:"type"`
+	Topic string          `json:"topic,omitempty"`
+	Event string          `json:"event,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Code  string          `json:"code,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+const (
+	// personal topics, each user only gets their own events on them
+	topicDirect        = "dm" // messages of every chat of the user
+	topicNotifications = "notifications"
+	topicPresence      = "presence" // presence of the users the user follows
+	// shared topics, followed by an ID
+	topicGroupPrefix = "group:"
+	// only used to send, to "dm:<user id>" for the 1:1 chat with someone or "chat:<id>"
+	topicDirectPrefix = "dm:"
+	topicChatPrefix   = "chat:"
+)
+
+const (
+	// undelivered messages replayed to a socket when it subscribes to "dm", older ones are
+	// left to the history endpoints
+	maxReplayedMessages = 500
+	replayBatchSize     = sendQueueSize / 2
+	replayPollInterval  = 50 * time.Millisecond
+	// longest client ID accepted on a sent message
+	maxClientIDLength = 64
+	// notifications replayed to a socket when it subscribes to "notifications", the latest
+	// ones win and older ones are left to GET /api/notifications
+	maxCaughtUpNotifications = replayBatchSize
+)
+
+var errUnknownTopic = errors.New("unknown topic")
+
+// topicError is a refused subscription or send, with the code to put in the error frame
+type topicError struct {
+	Code    string
+	Message string
+}
+
+func (e *topicError) Error() string {
+	return e.Message
+}
+
+// Gateway fans events out to the sockets subscribed to a topic. Every index is
+// guarded by mu, frames are only queued under it, never written.
+type Gateway struct {
+	mu       sync.RWMutex
+	users    map[int]map[*Client]bool
+	sessions map[string]map[*Client]bool
+	// shared topics only, personal ones are found through users
+	topics  map[string]map[*Client]bool
+	metrics gatewayMetrics
+}
+
+type gatewayMetrics struct {
+	connections         atomic.Int64
+	droppedFrames       atomic.Int64
+	overflowDisconnects atomic.Int64
+	slowWrites          atomic.Int64
+	writeErrors         atomic.Int64
+	heartbeatTimeouts   atomic.Int64
+}
+
+// GatewayMetrics is a snapshot of the gateway counters, all but Connections count since startup
+type GatewayMetrics struct {
+	Connections         int64 `json:"connections"`
+	DroppedFrames       int64 `json:"dropped_frames"`
+	OverflowDisconnects int64 `json:"overflow_disconnects"`
+	SlowWrites          int64 `json:"slow_writes"`
+	WriteErrors         int64 `json:"write_errors"`
+	HeartbeatTimeouts   int64 `json:"heartbeat_timeouts"`
+}
+
+// Metrics returns the current counters of the gateway
+func (g *Gateway) Metrics() GatewayMetrics {
+	return GatewayMetrics{
+		Connections:         g.metrics.connections.Load(),
+		DroppedFrames:       g.metrics.droppedFrames.Load(),
+		OverflowDisconnects: g.metrics.overflowDisconnects.Load(),
+		SlowWrites:          g.metrics.slowWrites.Load(),
+		WriteErrors:         g.metrics.writeErrors.Load(),
+		HeartbeatTimeouts:   g.metrics.heartbeatTimeouts.Load(),
+	}
+}
+
+// the gateway serving /ws, set once at startup
+var wsGateway *Gateway
+
+func NewGateway() *Gateway {
+	return &Gateway{
+		users:    make(map[int]map[*Client]bool),
+		sessions: make(map[string]map[*Client]bool),
+		topics:   make(map[string]map[*Client]bool),
+	}
+}
+
+func InitGateway(g *Gateway) {
+	wsGateway = g
+	models.OnNotification = publishNotification
+}
+
+func addToIndex[K comparable](index map[K]map[*Client]bool, key K, c *Client) {
+	if index[key] == nil {
+		index[key] = make(map[*Client]bool)
+	}
+	index[key][c] = true
+}
+
+func removeFromIndex[K comparable](index map[K]map[*Client]bool, key K, c *Client) {
+	if clients, ok := index[key]; ok {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+func isPersonalTopic(topic string) bool {
+	return topic == topicDirect || topic == topicNotifications || topic == topicPresence
+}
+
+func (g *Gateway) register(c *Client) {
+	g.mu.Lock()
+	before := g.userStatus(c.userID)
+	addToIndex(g.users, c.userID, c)
+	if c.sessionID != "" {
+		addToIndex(g.sessions, c.sessionID, c)
+	}
+	after := g.userStatus(c.userID)
+	g.mu.Unlock()
+
+	g.metrics.connections.Add(1)
+	if before != after {
+		g.presenceChanged(c.userID, after)
+	}
+}
+
+func (g *Gateway) unregister(c *Client) {
+	g.mu.Lock()
+	before := g.userStatus(c.userID)
+	removeFromIndex(g.users, c.userID, c)
+	removeFromIndex(g.sessions, c.sessionID, c)
+	for topic := range c.topics {
+		removeFromIndex(g.topics, topic, c)
+	}
+	c.topics = nil
+	after := g.userStatus(c.userID)
+	g.mu.Unlock()
+
+	g.metrics.connections.Add(-1)
+	if before != after {
+		g.presenceChanged(c.userID, after)
+	}
+}
+
+func (g *Gateway) subscribe(c *Client, topic string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c.topics == nil {
+		return
+	}
+	c.topics[topic] = true
+	if !isPersonalTopic(topic) {
+		addToIndex(g.topics, topic, c)
+	}
+}
+
+func (g *Gateway) unsubscribe(c *Client, topic string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(c.topics, topic)
+	removeFromIndex(g.topics, topic, c)
+}
+
+// UnsubscribeUser drops a topic from every socket of a user, telling them, when they may no longer follow it
+func (g *Gateway) UnsubscribeUser(userID int, topic string) {
+	g.mu.Lock()
+	var clients []*Client
+	for c := range g.users[userID] {
+		if c.topics[topic] {
+			delete(c.topics, topic)
+			removeFromIndex(g.topics, topic, c)
+			clients = append(clients, c)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, c := range clients {
+		c.send(Envelope{Type: "unsubscribed", Topic: topic})
+	}
+}
+
+// Publish sends an event to every socket subscribed to a shared topic
+func (g *Gateway) Publish(topic, event string, data interface{}) {
+	g.publishExcept(topic, event, data, 0)
+}
+
+// publishExcept sends an event on a shared topic to everyone but the sockets of one user
+func (g *Gateway) publishExcept(topic, event string, data interface{}, exceptUserID int) {
+	g.publishExceptUsers(topic, event, data, map[int]bool{exceptUserID: true})
+}
+
+// publishExceptUsers sends an event to the subscribers of a topic, leaving some users out
+func (g *Gateway) publishExceptUsers(topic, event string, data interface{}, except map[int]bool) {
+	g.mu.RLock()
+	clients := make([]*Client, 0, len(g.topics[topic]))
+	for c := range g.topics[topic] {
+		if !except[c.userID] {
+			clients = append(clients, c)
+		}
+	}
+	g.mu.RUnlock()
+
+	g.deliver(clients, topic, event, data)
+}
+
+// PublishToUser sends an event on a personal topic to the sockets of a user subscribed to it
+func (g *Gateway) PublishToUser(userID int, topic, event string, data interface{}) {
+	g.mu.RLock()
+	var clients []*Client
+	for c := range g.users[userID] {
+		if c.topics[topic] {
+			clients = append(clients, c)
+		}
+	}
+	g.mu.RUnlock()
+
+	g.deliver(clients, topic, event, data)
+}
+
+// deliverChatMessage pushes a message on the "dm" topic of a member. Unless they sent it,
+// their delivery position moves up to it once a socket received it. Sockets still replaying
+// what they missed get it after the replay.
+func (g *Gateway) deliverChatMessage(userID int, msg *models.ChatMessage) {
+	g.mu.RLock()
+	var clients []*Client
+	for c := range g.users[userID] {
+		if c.topics[topicDirect] {
+			clients = append(clients, c)
+		}
+	}
+	g.mu.RUnlock()
+
+	for _, c := range clients {
+		if !c.holdChatMessage(msg) {
+			g.queueChatMessage(c, msg)
+		}
+	}
+}
+
+// queueChatMessage queues a "message" event for one socket
+func (g *Gateway) queueChatMessage(c *Client, msg *models.ChatMessage) error {
+	frame, err := eventFrame(topicDirect, "message", msg)
+	if err != nil {
+		return err
+	}
+	var onWrite func()
+	if msg.SenderID != c.userID {
+		onWrite = func() {
+			if err := markChatDelivered(msg.ChatID, c.userID, msg.ID); err != nil {
+				log.Println("failed to mark a message as delivered:", err)
+			}
+		}
+	}
+	return c.enqueueFrame(outboundFrame{data: frame, onWrite: onWrite})
+}
+
+// replayUndelivered sends a socket that just subscribed to "dm" the messages that never reached
+// its user while they were away, oldest first. It only queues a batch once the writer caught
+// up with the previous one, so a long backlog doesn't overflow the queue. The caller marked
+// the client as replaying with startReplay.
+func (g *Gateway) replayUndelivered(c *Client) {
+	replayed := make(map[int]bool)
+	defer g.releaseHeld(c, replayed)
+
+	afterID := 0
+	for sent := 0; sent < maxReplayedMessages; sent += replayBatchSize {
+		messages, err := models.Db.GetUndeliveredMessages(c.userID, afterID, replayBatchSize)
+		if err != nil {
+			log.Println("failed to load undelivered messages:", err)
+			return
+		}
+		for i := range messages {
+			if err := g.queueChatMessage(c, &messages[i]); err != nil {
+				return
+			}
+			replayed[messages[i].ID] = true
+			afterID = messages[i].ID
+		}
+		if len(messages) < replayBatchSize {
+			return
+		}
+
+		for len(c.outbox) > replayBatchSize/2 {
+			select {
+			case <-c.done:
+				return
+			case <-time.After(replayPollInterval):
+			}
+		}
+	}
+}
+
+// releaseHeld queues the live messages held during a replay behind it, leaving out the ones
+// the replay already sent, and ends the replay
+func (g *Gateway) releaseHeld(c *Client, replayed map[int]bool) {
+	for {
+		held := c.takeHeld()
+		if len(held) == 0 {
+			return
+		}
+		for _, msg := range held {
+			if !replayed[msg.ID] {
+				g.queueChatMessage(c, msg)
+			}
+		}
+	}
+}
+
+func eventFrame(topic, event string, data interface{}) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{Type: "event", Topic: topic, Event: event, Data: payload})
+}
+
+func (g *Gateway) deliver(clients []*Client, topic, event string, data interface{}) {
+	if len(clients) == 0 {
+		return
+	}
+	frame, err := eventFrame(topic, event, data)
+	if err != nil {
+		log.Println("failed to encode event:", err)
+		return
+	}
+	// a client that can't take the frame is disconnected by enqueue, the others still get it
+	for _, c := range clients {
+		c.enqueue(frame)
+	}
+}
+
+// DisconnectSession tells every socket of a revoked session to log out, then closes it
+func (g *Gateway) DisconnectSession(sessionID string) {
+	g.mu.RLock()
+	clients := make([]*Client, 0, len(g.sessions[sessionID]))
+	for c := range g.sessions[sessionID] {
+		clients = append(clients, c)
+	}
+	g.mu.RUnlock()
+
+	for _, c := range clients {
+		c.send(Envelope{Type: "logout", Code: "session_revoked", Error: "this session was revoked"})
+		c.close(true, websocket.CloseNormalClosure, "session revoked")
+	}
+}
+
+// authorizeTopic checks a client may follow a topic
+func authorizeTopic(c *Client, topic string) error {
+	switch {
+	case topic == topicDirect, topic == topicPresence:
+		return nil
+	case topic == topicNotifications:
+		if !c.identity.Allows("notifications:read") {
+			return &topicError{Code: "insufficient_scope", Message: "this token needs the notifications:read scope"}
+		}
+		return nil
+	case strings.HasPrefix(topic, topicGroupPrefix):
+		groupID, err := strconv.Atoi(strings.TrimPrefix(topic, topicGroupPrefix))
+		if err != nil {
+			return errUnknownTopic
+		}
+		return checkGroupMember(c.userID, groupID)
+	}
+	return errUnknownTopic
+}
+
+func checkGroupMember(userID, groupID int) error {
+	isMember, err := models.Db.IsUserGroupMember(userID, groupID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return &topicError{Code: "not_a_member", Message: "Not a member of this group"}
+	}
+	return nil
+}
+
+// leaveGroupTopic unsubscribes the sockets of a user whose membership of a group ended
+func leaveGroupTopic(userID, groupID int) {
+	if wsGateway != nil {
+		wsGateway.UnsubscribeUser(userID, topicGroupPrefix+strconv.Itoa(groupID))
+	}
+}
+
+// messagePayload is the data of a "send" frame. A client retrying a send gives the same
+// client ID again, chat messages are only stored once per client ID of a user.
+type messagePayload struct {
+	Content       string `json:"content"`
+	ClientID      string `json:"client_id"`
+	AttachmentIDs []int  `json:"attachment_ids"`
+}
+
+// checkClientID refuses client IDs too long to be an idempotency key
+func checkClientID(clientID string) *topicError {
+	if len(clientID) > maxClientIDLength {
+		return &topicError{Code: "invalid_message", Message: fmt.Sprintf("client_id must be at most %d characters", maxClientIDLength)}
+	}
+	return nil
+}
+
+// checkCanSend makes sure the client may write in conversations
+func checkCanSend(c *Client) error {
+	if !c.identity.Allows("messages:send") {
+		return &topicError{Code: "insufficient_scope", Message: "this token needs the messages:send scope"}
+	}
+	if !canSendMessages(c.userID) {
+		return &topicError{Code: "email_not_verified", Message: "please verify your email address first"}
+	}
+	return nil
+}
+
+// handleSend stores a message sent on a socket and publishes it, the stored message
+// is returned so the sender learns its ID
+func (g *Gateway) handleSend(c *Client, env Envelope) (interface{}, error) {
+	if err := checkCanSend(c); err != nil {
+		return nil, err
+	}
+
+	var payload messagePayload
+	if err := json.Unmarshal(env.Data, &payload); err != nil {
+		return nil, &topicError{Code: "invalid_message", Message: "content is required"}
+	}
+	if err := checkMessageContent(payload.Content, len(payload.AttachmentIDs)); err != nil {
+		return nil, err
+	}
+	if err := checkAttachmentIDs(payload.AttachmentIDs); err != nil {
+		return nil, err
+	}
+	if err := checkClientID(payload.ClientID); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(env.Topic, topicDirectPrefix):
+		receiverID, err := strconv.Atoi(strings.TrimPrefix(env.Topic, topicDirectPrefix))
+		if err != nil {
+			return nil, errUnknownTopic
+		}
+		if receiverID == c.userID {
+			return nil, &topicError{Code: "user_not_found", Message: "user not found"}
+		}
+		if _, err := models.Db.GetUserByID(receiverID); err != nil {
+			return nil, &topicError{Code: "user_not_found", Message: "user not found"}
+		}
+		request, err := isMessageRequest(c.userID, receiverID)
+		if err != nil {
+			return nil, err
+		}
+		chatID, err := models.Db.GetOrCreateDirectChat(c.userID, receiverID, request)
+		if err != nil {
+			return nil, err
+		}
+		msg, _, err := sendChatMessage(chatID, c.userID, payload.Content, payload.ClientID, payload.AttachmentIDs)
+		return msg, err
+
+	case strings.HasPrefix(env.Topic, topicChatPrefix):
+		chatID, err := strconv.Atoi(strings.TrimPrefix(env.Topic, topicChatPrefix))
+		if err != nil {
+			return nil, errUnknownTopic
+		}
+		isMember, err := models.Db.IsChatMember(chatID, c.userID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, &topicError{Code: "chat_not_found", Message: "chat not found"}
+		}
+		msg, _, err := sendChatMessage(chatID, c.userID, payload.Content, payload.ClientID, payload.AttachmentIDs)
+		return msg, err
+
+	case strings.HasPrefix(env.Topic, topicGroupPrefix):
+		groupID, err := strconv.Atoi(strings.TrimPrefix(env.Topic, topicGroupPrefix))
+		if err != nil {
+			return nil, errUnknownTopic
+		}
+		if err := checkGroupMember(c.userID, groupID); err != nil {
+			return nil, err
+		}
+		user, err := models.Db.GetUserByID(c.userID)
+		if err != nil {
+			return nil, err
+		}
+		return g.sendGroupMessage(user, groupID, payload.Content, payload.AttachmentIDs)
+	}
+	return nil, errUnknownTopic
+}
+
+// sendGroupMessage stores a group message and pushes it to the group's subscribers
+func (g *Gateway) sendGroupMessage(user *models.User, groupID int, text string, attachmentIDs []int) (*models.GroupMessage, error) {
+	msg, err := storeGroupMessage(user, groupID, text, attachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+	g.Publish(topicGroupPrefix+strconv.Itoa(groupID), "message", msg)
+	return &msg, nil
+}
+
+func storeGroupMessage(user *models.User, groupID int, text string, attachmentIDs []int) (models.GroupMessage, error) {
+	msg := models.GroupMessage{
+		GroupID:   groupID,
+		SenderID:  user.ID,
+		Sender:    user.Nickname.String,
+		Text:      text,
+		CreatedAt: time.Now().UTC(),
+	}
+	err := models.Db.InsertGroupMessage(&msg, attachmentIDs)
+	if err != nil && err.Error() == "attachment not available" {
+		return msg, errAttachmentNotAvailable
+	}
+	return msg, err
+}
+
+// publishGroupMessage pushes a group message stored outside of a socket, like from the REST API
+func publishGroupMessage(msg models.GroupMessage) {
+	if wsGateway != nil {
+		wsGateway.Publish(topicGroupPrefix+strconv.Itoa(msg.GroupID), "message", msg)
+	}
+}
+
+// sendNotification stores a notification, the gateway pushes it to its receiver
+func sendNotification(notification *models.Notification) {
+	if _, err := models.Db.InsertNotification(notification); err != nil {
+		log.Println("failed to insert notification:", err)
+	}
+}
+
+// unreadNotificationsEvent is pushed on "notifications" whenever the unread count of a user changes
+type unreadNotificationsEvent struct {
+	UnreadCount int `json:"unread_count"`
+}
+
+// caughtUpEvent ends the notifications replayed to a socket, HasMore tells there were
+// more than it got and the rest is left to GET /api/notifications
+type caughtUpEvent struct {
+	HasMore bool `json:"has_more"`
+}
+
+// notificationsSubscription is the optional data of a subscription to "notifications",
+// After is the latest notification the client already has, 0 when it has none
+type notificationsSubscription struct {
+	After *int `json:"after"`
+}
+
+// publishNotification pushes a new notification to its receiver, with their unread count
+func publishNotification(notification models.Notification) {
+	if wsGateway != nil {
+		wsGateway.deliverNotification(notification)
+		publishUnreadNotifications(notification.ReceiverId)
+	}
+}
+
+// deliverNotification pushes a notification on the "notifications" topic of its receiver,
+// sockets still catching up with the ones they missed get it after the catch-up
+func (g *Gateway) deliverNotification(notification models.Notification) {
+	g.mu.RLock()
+	var clients []*Client
+	for c := range g.users[notification.ReceiverId] {
+		if c.topics[topicNotifications] {
+			clients = append(clients, c)
+		}
+	}
+	g.mu.RUnlock()
+
+	for _, c := range clients {
+		if !c.holdNotification(notification) {
+			g.sendEvent(c, topicNotifications, "notification", notification)
+		}
+	}
+}
+
+// publishUnreadNotifications pushes the unread count of a user to their sockets
+func publishUnreadNotifications(userID int) {
+	if wsGateway == nil {
+		return
+	}
+	count, err := models.Db.GetUnreadCount(userID)
+	if err != nil {
+		log.Println("failed to count unread notifications:", err)
+		return
+	}
+	wsGateway.PublishToUser(userID, topicNotifications, "unread_count", unreadNotificationsEvent{UnreadCount: count})
+}
+
+// catchUpNotifications sends a socket that just subscribed to "notifications" what its
+// user missed after the notification the client had, oldest first, then their unread count.
+// With afterID, the caller marked the client as catching up with startCatchUp.
+func (g *Gateway) catchUpNotifications(c *Client, afterID *int) {
+	if afterID != nil {
+		newest, ok := g.sendMissedNotifications(c, *afterID)
+		g.releaseHeldNotifications(c, newest)
+		if !ok {
+			return
+		}
+	}
+
+	count, err := models.Db.GetUnreadCount(c.userID)
+	if err != nil {
+		log.Println("failed to count unread notifications:", err)
+		return
+	}
+	g.sendEvent(c, topicNotifications, "unread_count", unreadNotificationsEvent{UnreadCount: count})
+}
+
+// sendMissedNotifications sends the notifications newer than afterID and "caught_up". It
+// returns the newest notification sent, afterID when none was, and false once the socket is gone.
+func (g *Gateway) sendMissedNotifications(c *Client, afterID int) (int, bool) {
+	notifications, err := models.Db.GetNotificationsAfter(c.userID, afterID, maxCaughtUpNotifications+1)
+	if err != nil {
+		log.Println("failed to load missed notifications:", err)
+		return afterID, false
+	}
+	hasMore := len(notifications) > maxCaughtUpNotifications
+	if hasMore {
+		notifications = notifications[1:]
+	}
+	newest := afterID
+	for _, notification := range notifications {
+		if !g.sendEvent(c, topicNotifications, "notification", notification) {
+			return newest, false
+		}
+		newest = notification.Id
+	}
+	return newest, g.sendEvent(c, topicNotifications, "caught_up", caughtUpEvent{HasMore: hasMore})
+}
+
+// releaseHeldNotifications sends the live notifications held during a catch-up behind it,
+// leaving out the ones it already sent, and ends the catch-up
+func (g *Gateway) releaseHeldNotifications(c *Client, newest int) {
+	for {
+		held := c.takeHeldNotifications()
+		if len(held) == 0 {
+			return
+		}
+		for _, notification := range held {
+			if notification.Id > newest {
+				g.sendEvent(c, topicNotifications, "notification", notification)
+			}
+		}
+	}
+}
+
+// sendEvent queues an event for one socket, false once the socket is gone
+func (g *Gateway) sendEvent(c *Client, topic, event string, data interface{}) bool {
+	frame, err := eventFrame(topic, event, data)
+	if err != nil {
+		log.Println("failed to encode event:", err)
+		return false
+	}
+	return c.enqueueFrame(outboundFrame{data: frame}) == nil
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -11,8 +12,9 @@ import (
 )
 
 type GroupChatMessageRequest struct {
-	GroupID int    `json:"group_id"`
-	Text    string `json:"text"`
+	GroupID       int    `json:"group_id"`
+	Text          string `json:"text"`
+	AttachmentIDs []int  `json:"attachment_ids"`
 }
 
 type GroupChatMessageResponse struct {
@@ -48,17 +50,25 @@ func PostGroupMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	msg := models.GroupMessage{
-		GroupID:   req.GroupID,
-		SenderID:  user.ID,
-		Sender:    user.Nickname.String,
-		Text:      req.Text,
-		CreatedAt: time.Now(),
+	if err := checkMessageContent(req.Text, len(req.AttachmentIDs)); err != nil {
+		tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, err.Code, err.Message)
+		return
+	}
+	if err := checkAttachmentIDs(req.AttachmentIDs); err != nil {
+		tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, err.Code, err.Message)
+		return
+	}
+
+	msg, err := storeGroupMessage(user, req.GroupID, req.Text, req.AttachmentIDs)
+	if err == errAttachmentNotAvailable {
+		tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, errAttachmentNotAvailable.Code, errAttachmentNotAvailable.Message)
+		return
 	}
-	if err := models.Db.InsertGroupMessage(msg); err != nil {
+	if err != nil {
 		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "Failed to save message")
 		return
 	}
+	publishGroupMessage(msg)
 	tools.JSONResponse(w, http.StatusOK, msg)
 }
 
@@ -85,20 +95,91 @@ func GetGroupMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
-	if err != nil || limit <= 0 {
-		limit = 50
+	q, ok := pageQueryFromRequest(w, r)
+	if !ok {
+		return
 	}
 
-	msgs, err := models.Db.GetGroupMessages(groupID, limit)
+	page, err := models.Db.GetGroupMessages(groupID, user.ID, q)
 	if err != nil {
+		if err.Error() == "message not found" {
+			tools.ErrorJSONResponse(w, http.StatusNotFound, "message not found")
+			return
+		}
 		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "Failed to fetch messages")
 		return
 	}
+	tools.JSONResponse(w, http.StatusOK, page)
+}
+
+// the longest retention a group can pick, beyond that it might as well keep messages forever
+const maxRetentionDays = 3650
+
+// GroupRetentionRequest sets how many days group messages are kept, null keeps them forever
+type GroupRetentionRequest struct {
+	Days *int `json:"days"`
+}
+
+// GroupRetentionHandler reads (GET, members) or changes (PUT, creator) the message retention of a group
+func GroupRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(int)
+
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || groupID <= 0 {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid group ID")
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(msgs); err != nil {
-		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "Failed to encode messages")
+	isMember, err := models.Db.IsUserGroupMember(userID, groupID)
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "Failed to verify group membership")
 		return
 	}
+	if !isMember {
+		tools.ErrorJSONResponse(w, http.StatusForbidden, "Not a member of this group")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		days, err := models.Db.GetGroupMessageRetention(groupID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		tools.JSONResponse(w, http.StatusOK, GroupRetentionRequest{Days: days})
+
+	case http.MethodPut:
+		creatorID, err := models.Db.GetGroupCreator(groupID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if creatorID != userID {
+			tools.ErrorJSONResponse(w, http.StatusForbidden, "only the group creator can change the retention")
+			return
+		}
+
+		var req GroupRetentionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if req.Days != nil && (*req.Days < 1 || *req.Days > maxRetentionDays) {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d, or null to keep messages forever", maxRetentionDays))
+			return
+		}
+
+		if err := models.Db.SetGroupMessageRetention(groupID, req.Days); err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		tools.JSONResponse(w, http.StatusOK, req)
+
+	default:
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
 }
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+// GroupMessageHandler edits (PATCH) or deletes (DELETE) a group message, like ChatMessageHandler
+// does for chats. Only the sender may edit it, within tools.MessageEditWindow, or delete it
+// ?for=everyone; any member can delete it for themselves.
+func GroupMessageHandler(w http.ResponseWriter, r *http.Request) {
+	groupID, msg, ok := groupMessageFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case http.MethodPatch:
+		var req GroupChatMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if msg.SenderID != userID {
+			tools.ErrorJSONResponse(w, http.StatusForbidden, "you can only edit your own messages")
+			return
+		}
+		if msg.Deleted {
+			tools.ErrorJSONResponse(w, http.StatusConflict, "this message was deleted")
+			return
+		}
+		if !canEditMessage(msg.CreatedAt) {
+			tools.ErrorCodeJSONResponse(w, http.StatusForbidden, "edit_window_passed",
+				fmt.Sprintf("messages can only be edited for %s after being sent", tools.MessageEditWindow))
+			return
+		}
+		if err := checkMessageContent(req.Text, len(msg.Attachments)); err != nil {
+			tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, err.Code, err.Message)
+			return
+		}
+
+		if req.Text != msg.Text {
+			if err := models.Db.EditGroupMessage(msg.ID, req.Text); err != nil {
+				fmt.Println(err)
+				tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			msg = publishGroupMessageUpdate(w, groupID, msg.ID)
+			if msg == nil {
+				return
+			}
+		}
+		tools.JSONResponse(w, http.StatusOK, msg)
+
+	case http.MethodDelete:
+		switch r.URL.Query().Get("for") {
+		case "", "me":
+			if err := models.Db.HideGroupMessage(msg.ID, userID); err != nil {
+				fmt.Println(err)
+				tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			if wsGateway != nil {
+				wsGateway.PublishToUser(userID, topicGroupPrefix+strconv.Itoa(groupID), "message_hidden",
+					hiddenMessageEvent{GroupID: groupID, MessageID: msg.ID})
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case "everyone":
+			if msg.SenderID != userID {
+				tools.ErrorJSONResponse(w, http.StatusForbidden, "you can only delete your own messages for everyone")
+				return
+			}
+			if !msg.Deleted {
+				if err := models.Db.DeleteGroupMessage(msg.ID); err != nil {
+					fmt.Println(err)
+					tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+					return
+				}
+				msg = publishGroupMessageUpdate(w, groupID, msg.ID)
+				if msg == nil {
+					return
+				}
+			}
+			tools.JSONResponse(w, http.StatusOK, msg)
+
+		default:
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "for must be me or everyone")
+		}
+
+	default:
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// GroupMessageEditsHandler returns the previous versions of a group message, oldest first
+func GroupMessageEditsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	_, msg, ok := groupMessageFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	edits, err := models.Db.GetGroupMessageEdits(msg.ID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	tools.JSONResponse(w, http.StatusOK, edits)
+}
+
+// GroupMessageReactionHandler adds (PUT) or removes (DELETE) the current user's reaction to a group message
+func GroupMessageReactionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	groupID, msg, ok := groupMessageFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	emoji := r.PathValue("emoji")
+	if !isEmoji(emoji) {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected an emoji")
+		return
+	}
+	if msg.Deleted {
+		tools.ErrorJSONResponse(w, http.StatusConflict, "this message was deleted")
+		return
+	}
+
+	var changed bool
+	var err error
+	if r.Method == http.MethodPut {
+		if err = checkReactionLimit(msg.Reactions, emoji); err == nil {
+			changed, err = models.Db.AddGroupMessageReaction(msg.ID, userID, emoji)
+		}
+	} else {
+		changed, err = models.Db.RemoveGroupMessageReaction(msg.ID, userID, emoji)
+	}
+	if err != nil {
+		if refused, ok := err.(*topicError); ok {
+			tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, refused.Code, refused.Message)
+			return
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if changed {
+		msg = publishGroupMessageUpdate(w, groupID, msg.ID)
+		if msg == nil {
+			return
+		}
+	}
+	tools.JSONResponse(w, http.StatusOK, msg)
+}
+
+// groupMessageFromRequest loads the group message of the path, answering 404 unless the
+// current user is a member of its group
+func groupMessageFromRequest(w http.ResponseWriter, r *http.Request) (int, *models.GroupMessage, bool) {
+	userID := r.Context().Value("userID").(int)
+
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || groupID <= 0 {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid group ID")
+		return 0, nil, false
+	}
+	if err := checkGroupMember(userID, groupID); err != nil {
+		if _, ok := err.(*topicError); ok {
+			tools.ErrorJSONResponse(w, http.StatusNotFound, "group not found")
+			return 0, nil, false
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return 0, nil, false
+	}
+
+	messageID, err := strconv.Atoi(r.PathValue("messageID"))
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected message id")
+		return 0, nil, false
+	}
+
+	msg, err := models.Db.GetGroupMessage(groupID, messageID)
+	if err != nil {
+		if err.Error() == "message not found" {
+			tools.ErrorJSONResponse(w, http.StatusNotFound, "message not found")
+			return 0, nil, false
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return 0, nil, false
+	}
+	return groupID, msg, true
+}
+
+// publishGroupMessageUpdate reloads a changed group message and pushes it to the group's
+// subscribers who still see it. It answers 500 itself and returns nil when the message can't be loaded.
+func publishGroupMessageUpdate(w http.ResponseWriter, groupID, messageID int) *models.GroupMessage {
+	msg, err := models.Db.GetGroupMessage(groupID, messageID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return nil
+	}
+
+	if wsGateway != nil {
+		hiders, err := models.Db.GetGroupMessageHiders(messageID)
+		if err != nil {
+			fmt.Println(err)
+			return msg
+		}
+		wsGateway.publishExceptUsers(topicGroupPrefix+strconv.Itoa(groupID), "message_updated", msg, hiders)
+	}
+	return msg
+}
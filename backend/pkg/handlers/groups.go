@@ -137,7 +137,7 @@ func GroupInviteHandler(w http.ResponseWriter, r *http.Request) {
 					SenderId:   userID,
 					ReceiverId: invitedUserID,
 				}
-				models.Db.InsertNotification(notification)
+				sendNotification(notification)
 			}
 		}
 
@@ -221,7 +221,7 @@ func GroupRequestHandler(w http.ResponseWriter, r *http.Request) {
 					SenderId:   userID,
 					ReceiverId: creatorID,
 				}
-				models.Db.InsertNotification(notification)
+				sendNotification(notification)
 			}
 		}
 
@@ -275,11 +275,18 @@ func GroupRequestHandler(w http.ResponseWriter, r *http.Request) {
 						SenderId:   userID, // The user who made the request
 						ReceiverId: userID, // Notify the same user
 					}
-					models.Db.InsertNotification(notification)
+					sendNotification(notification)
 				}
 			}
 		} else if request.Action == "reject" {
-			err = models.Db.RemoveGroupMemberById(request.MemberID)
+			var memberUserID int
+			memberUserID, err = models.Db.GetUserIDFromMember(request.MemberID)
+			if err == nil {
+				err = models.Db.RemoveGroupMemberById(request.MemberID)
+			}
+			if err == nil {
+				leaveGroupTopic(memberUserID, groupID)
+			}
 		} else {
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid action"})
@@ -471,7 +478,7 @@ func GroupInvitationResponseHandler(w http.ResponseWriter, r *http.Request) {
 				SenderId:   userID,
 				ReceiverId: creatorID,
 			}
-			models.Db.InsertNotification(notification)
+			sendNotification(notification)
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -485,6 +492,7 @@ func GroupInvitationResponseHandler(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 			return
 		}
+		leaveGroupTopic(userID, request.GroupID)
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{"message": "Invitation rejected successfully"})
 		return
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+type LockoutsResponse struct {
+	LockedUntil *time.Time              `json:"locked_until"`
+	Lockouts    []models.AccountLockout `json:"lockouts"`
+}
+
+// LockoutsHandler shows the current user when their account was locked after failed logins
+func LockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	lockedUntil, err := tools.AccountLockedUntil(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	lockouts, err := models.Db.GetAccountLockouts(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if lockouts == nil {
+		lockouts = []models.AccountLockout{}
+	}
+
+	tools.JSONResponse(w, http.StatusOK, LockoutsResponse{LockedUntil: lockedUntil, Lockouts: lockouts})
+}
+
+// UnlockUserHandler lets an admin lift the lockout of an account before it expires
+func UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	adminID := r.Context().Value("userID").(int)
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected user id")
+		return
+	}
+	if _, err := models.Db.GetUserByID(userID); err != nil {
+		tools.ErrorJSONResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	if err := tools.UnlockAccount(userID, adminID); err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, AccountResponse{Message: "account unlocked"})
+}
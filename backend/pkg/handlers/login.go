@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"social-network/pkg/models"
 	"social-network/pkg/tools"
 )
 
@@ -15,9 +18,18 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Message string      `json:"message"`
-	User    interface{} `json:"user"`
-	Token   string      `json:"token"`
+	Message      string      `json:"message"`
+	User         interface{} `json:"user"`
+	Token        string      `json:"token"`
+	RefreshToken string      `json:"refresh_token"`
+	ExpiresIn    int         `json:"expires_in"`
+}
+
+type TwoFactorChallengeResponse struct {
+	Message           string `json:"message"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"`
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
@@ -35,38 +47,96 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, statusCode, err := tools.CheckLoginInfto(LoginInfo.Login, LoginInfo.Password)
+	_, ip := tools.ClientInfo(r)
+	user, statusCode, err := tools.CheckLoginInfto(LoginInfo.Login, LoginInfo.Password, ip)
 	if err != nil {
+		var locked *tools.LoginLockedError
+		if errors.As(err, &locked) {
+			loginLocked(w, locked)
+			return
+		}
 		tools.ErrorJSONResponse(w, statusCode, err.Error())
 		return
 	}
 
+	twoFactor, err := tools.IsTwoFactorEnabled(user.ID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// the password is right, but the session is only opened once a code is sent to /api/login/2fa
+	if twoFactor {
+		challenge, err := tools.StartLoginChallenge(user.ID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		tools.JSONResponse(w, http.StatusOK, TwoFactorChallengeResponse{
+			Message:           "two-factor authentication required",
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresIn:         int(tools.LoginChallengeLifetime.Seconds()),
+		})
+		return
+	}
+
+	startSession(w, r, user)
+}
+
+// startSession opens a session for a user whose credentials were checked and sends its tokens
+func startSession(w http.ResponseWriter, r *http.Request, user *models.User) {
 	// Use nickname if available, else default to "Anonymous"
 	nickname := user.Nickname.String
 	if nickname == "" {
 		nickname = "Anonymous"
 	}
-	JWTToken, err := tools.GenerateJWTToken(user.ID, nickname)
+	userAgent, ip := tools.ClientInfo(r)
+	tokens, err := tools.GenerateJWTToken(user.ID, nickname, userAgent, ip)
 	if err != nil {
 		fmt.Println(err)
 		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "can't generate a new JWT token, try againe")
 		return
 	}
 
+	tools.RecordLoginSuccess(user.ID)
+	setTokenCookies(w, tokens)
+
+	apiResponse := LoginResponse{
+		Message:      "connected successfully",
+		User:         user,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}
+
+	tools.JSONResponse(w, http.StatusOK, apiResponse)
+}
+
+func loginLocked(w http.ResponseWriter, locked *tools.LoginLockedError) {
+	w.Header().Set("Retry-After", strconv.Itoa(locked.RetryAfter()))
+	tools.ErrorCodeJSONResponse(w, http.StatusTooManyRequests, "login_locked", locked.Error())
+}
+
+func setTokenCookies(w http.ResponseWriter, tokens *tools.TokenPair) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "JWT_token",
-		Value:    JWTToken,
+		Value:    tokens.AccessToken,
 		HttpOnly: true,
 		Secure:   false,
 		Path:     "/",
-		Expires:  time.Now().Add(200 * 365 * 24 * time.Hour), // Long-lived token
+		Expires:  time.Now().Add(tools.AccessTokenLifetime),
 	})
 
-	apiResponse := LoginResponse{
-		Message: "connected successfully",
-		User:    user,
-		Token:   JWTToken,
-	}
-
-	tools.JSONResponse(w, http.StatusOK, apiResponse)
+	// the refresh token is only ever sent to the refresh endpoint
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    tokens.RefreshToken,
+		HttpOnly: true,
+		Secure:   false,
+		Path:     "/api/token",
+		Expires:  time.Now().Add(tools.RefreshTokenLifetime),
+	})
 }
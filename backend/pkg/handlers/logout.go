@@ -17,12 +17,24 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tokenID, _ := r.Context().Value("tokenID").(string)
+	if err := revokeSession(tokenID); err != nil {
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
 	http.SetCookie(w, &http.Cookie{
 		Name:   "JWT_token",
 		Value:  "",
 		Path:   "/",
 		MaxAge: -1,
 	})
+	http.SetCookie(w, &http.Cookie{
+		Name:   "refresh_token",
+		Value:  "",
+		Path:   "/api/token",
+		MaxAge: -1,
+	})
 
 	var apiResponse = LogoutResponse{
 		Message: "you're logged out",
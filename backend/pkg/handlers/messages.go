@@ -1,34 +1,79 @@
 package handlers
 
 import (
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
 )
 
+// the legacy endpoint has no pagination, it stops at the latest messages
+const legacyHistoryLimit = 1000
+
+// GetMessages returns the 1:1 conversation between the current user and ?user=<id>,
+// in the format clients used before chats. The older ?sender=&receiver= form is still
+// accepted, as long as one of them is the current user.
 func GetMessages(w http.ResponseWriter, r *http.Request) {
-	sender := r.URL.Query().Get("sender")
-	receiver := r.URL.Query().Get("receiver")
-
-	rows, err := DB.Query(`
-        SELECT sender_id, receiver_id, content, timestamp
-        FROM messages
-        WHERE (sender_id = ? AND receiver_id = ?)
-           OR (sender_id = ? AND receiver_id = ?)
-        ORDER BY timestamp ASC
-    `, sender, receiver, receiver, sender)
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	me := strconv.Itoa(userID)
+
+	query := r.URL.Query()
+	other := query.Get("user")
+	if other == "" {
+		sender, receiver := query.Get("sender"), query.Get("receiver")
+		switch me {
+		case sender:
+			other = receiver
+		case receiver:
+			other = sender
+		default:
+			tools.ErrorJSONResponse(w, http.StatusForbidden, "you can only read your own conversations")
+			return
+		}
+	}
+	otherID, err := strconv.Atoi(other)
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected user id")
+		return
+	}
+
+	messages := []Message{}
+	chatID, err := models.Db.GetDirectChatID(userID, otherID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if chatID == 0 {
+		tools.JSONResponse(w, http.StatusOK, messages)
+		return
+	}
+
+	history, err := models.Db.GetChatMessages(chatID, userID, models.PageQuery{Limit: legacyHistoryLimit})
 	if err != nil {
-		http.Error(w, "DB error", 500)
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
-	defer rows.Close()
-
-	var messages []Message
-	for rows.Next() {
-		var m Message
-		rows.Scan(&m.Sender, &m.Receivers, &m.Content, &m.Timestamp)
-		m.Receivers = []string{m.Receivers[0]} 
-		messages = append(messages, m)
+	for _, m := range history.Messages {
+		receiver := other
+		if m.SenderID == otherID {
+			receiver = me
+		}
+		messages = append(messages, Message{
+			Type:      "messageuser",
+			Sender:    strconv.Itoa(m.SenderID),
+			Receivers: []string{receiver},
+			Content:   m.Content,
+			Timestamp: m.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	tools.JSONResponse(w, http.StatusOK, messages)
 }
@@ -2,60 +2,250 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"social-network/pkg/models"
+	"social-network/pkg/tools"
 )
 
-// NotificationsHandler handles notifications
+const (
+	defaultNotificationsLimit = 20
+	maxNotificationsLimit     = 100
+	// notifications one request can change by ID, "all" has no limit
+	maxNotificationIDs = 100
+)
+
+// NotificationsPage is a page of notifications, the latest first. The next page is read
+// with ?before= and the ID of the last one.
+type NotificationsPage struct {
+	Notifications []models.Notification `json:"notifications"`
+	UnreadCount   int                   `json:"unread_count"`
+	HasMore       bool                  `json:"has_more"`
+}
+
+// NotificationSelectionRequest picks the notifications an action applies to, one of
+// a single ID, a set of IDs or all the notifications of the current user
+type NotificationSelectionRequest struct {
+	NotificationID  int   `json:"notification_id"`
+	NotificationIDs []int `json:"notification_ids"`
+	All             bool  `json:"all"`
+}
+
+// NotificationsUpdate answers an action with the number of notifications it changed
+type NotificationsUpdate struct {
+	Updated     int64 `json:"updated"`
+	UnreadCount int   `json:"unread_count"`
+}
+
+// notificationsChangedEvent tells the other devices of a user what an action changed,
+// NotificationIDs is empty when it applied to all of them
+type notificationsChangedEvent struct {
+	Action          string `json:"action"`
+	NotificationIDs []int  `json:"notification_ids,omitempty"`
+	All             bool   `json:"all,omitempty"`
+}
+
+// NotificationsHandler returns a page of the current user's notifications. ?type= and
+// ?read=true|false filter them, and ?archived=true lists the archived ones instead.
 func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
+	userID := r.Context().Value("userID").(int)
 
-	userID, ok := r.Context().Value("userID").(int)
-	if !ok || userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
+	q, ok := notificationQueryFromRequest(w, r)
+	if !ok {
 		return
 	}
 
-	notifications, err := models.Db.GetNotifications(userID)
+	notifications, hasMore, err := models.Db.GetNotifications(userID, q)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	unreadCount, err := models.Db.GetUnreadCount(userID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
+	if notifications == nil {
+		notifications = []models.Notification{}
+	}
+	tools.JSONResponse(w, http.StatusOK, NotificationsPage{Notifications: notifications, UnreadCount: unreadCount, HasMore: hasMore})
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"notifications": notifications,
-	})
+// NotificationActionHandler returns the handler of POST /api/notifications/<action>: read,
+// unread, archive, unarchive or delete, applied to the notifications picked by the body
+func NotificationActionHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		userID := r.Context().Value("userID").(int)
+
+		var req NotificationSelectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		ids, ok := notificationSelection(w, req)
+		if !ok {
+			return
+		}
+		applyNotificationAction(w, userID, action, ids)
+	}
 }
 
-func MarkNotificationAsReadHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// NotificationHandler deletes (DELETE) one notification of the current user
+func NotificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	var req struct {
-		NotificationID int `json:"notification_id"`
+	userID := r.Context().Value("userID").(int)
+
+	notificationID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || notificationID <= 0 {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected notification id")
+		return
 	}
-	err := json.NewDecoder(r.Body).Decode(&req)
-	// fmt.Println("MarkNotificationAsReadHandler called", err)
+
+	deleted, err := models.Db.RemoveNotifications(userID, []int{notificationID})
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "invalid notification_id"})
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
-	// fmt.Println("Marking notification as read:", req.NotificationID)
+	if deleted == 0 {
+		tools.ErrorJSONResponse(w, http.StatusNotFound, "notification not found")
+		return
+	}
+	publishNotificationsChanged(userID, "delete", []int{notificationID})
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	if err := models.Db.MarkNotificationAsRead(req.NotificationID); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+// applyNotificationAction changes the notifications of a user, every one of them when ids
+// is nil, and tells their sockets
+func applyNotificationAction(w http.ResponseWriter, userID int, action string, ids []int) {
+	var updated int64
+	var err error
+	switch action {
+	case "read", "unread":
+		updated, err = models.Db.SetNotificationsRead(userID, ids, action == "read")
+	case "archive", "unarchive":
+		updated, err = models.Db.SetNotificationsArchived(userID, ids, action == "archive")
+	case "delete":
+		updated, err = models.Db.RemoveNotifications(userID, ids)
+	default:
+		err = fmt.Errorf("unknown notification action %q", action)
+	}
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	if updated > 0 {
+		publishNotificationsChanged(userID, action, ids)
+	}
+	unreadCount, err := models.Db.GetUnreadCount(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	tools.JSONResponse(w, http.StatusOK, NotificationsUpdate{Updated: updated, UnreadCount: unreadCount})
+}
+
+// publishNotificationsChanged tells the sockets of a user that their notifications changed, with their unread count
+func publishNotificationsChanged(userID int, action string, ids []int) {
+	if wsGateway == nil {
+		return
+	}
+	wsGateway.PublishToUser(userID, topicNotifications, "notifications_changed",
+		notificationsChangedEvent{Action: action, NotificationIDs: ids, All: ids == nil})
+	publishUnreadNotifications(userID)
+}
+
+// notificationSelection reads the notifications a request picks, nil for all of them
+func notificationSelection(w http.ResponseWriter, req NotificationSelectionRequest) ([]int, bool) {
+	picked := 0
+	if req.NotificationID != 0 {
+		picked++
+	}
+	if req.NotificationIDs != nil {
+		picked++
+	}
+	if req.All {
+		picked++
+	}
+	if picked != 1 {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "give one of notification_id, notification_ids and all")
+		return nil, false
+	}
+
+	if req.All {
+		return nil, true
+	}
+	ids := req.NotificationIDs
+	if req.NotificationID != 0 {
+		ids = []int{req.NotificationID}
+	}
+	if len(ids) == 0 || len(ids) > maxNotificationIDs {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("give between 1 and %d notification ids", maxNotificationIDs))
+		return nil, false
+	}
+	for _, id := range ids {
+		if id <= 0 {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "notification ids must be positive")
+			return nil, false
+		}
+	}
+	return ids, true
+}
+
+// notificationQueryFromRequest reads the ?before=, ?limit=, ?type=, ?read= and ?archived= of a notifications request
+func notificationQueryFromRequest(w http.ResponseWriter, r *http.Request) (models.NotificationQuery, bool) {
+	query := r.URL.Query()
+	q := models.NotificationQuery{Limit: defaultNotificationsLimit, Type: query.Get("type")}
+
+	if value := query.Get("before"); value != "" {
+		before, err := strconv.Atoi(value)
+		if err != nil || before <= 0 {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "before must be a notification id")
+			return q, false
+		}
+		q.Before = before
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "limit must be a positive number")
+			return q, false
+		}
+		q.Limit = min(limit, maxNotificationsLimit)
+	}
+	if value := query.Get("read"); value != "" {
+		read, err := strconv.ParseBool(value)
+		if err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "read must be true or false")
+			return q, false
+		}
+		q.Read = &read
+	}
+	if value := query.Get("archived"); value != "" {
+		archived, err := strconv.ParseBool(value)
+		if err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "archived must be true or false")
+			return q, false
+		}
+		q.Archived = archived
+	}
+	return q, true
 }
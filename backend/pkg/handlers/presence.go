@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+const (
+	statusOnline  = "online"
+	statusAway    = "away"
+	statusOffline = "offline"
+	// a client can say it is typing in a conversation once per interval, the rest is dropped
+	typingInterval = 3 * time.Second
+)
+
+// Presence is what the followers of a user see of them
+type Presence struct {
+	UserID int    `json:"user_id"`
+	Status string `json:"status"`
+	// only set while offline, nil when the user never connected
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+type PresenceSettings struct {
+	Hidden bool `json:"hidden"`
+}
+
+// presencePayload is the data of a "presence" frame
+type presencePayload struct {
+	Status string `json:"status"`
+}
+
+// typingEvent is pushed to the other members of a conversation, never stored
+type typingEvent struct {
+	ChatID  int `json:"chat_id,omitempty"`
+	GroupID int `json:"group_id,omitempty"`
+	UserID  int `json:"user_id"`
+}
+
+// userStatus sums up the sockets of a user, the caller holds the gateway lock.
+// A user is online as soon as one of their sockets isn't away.
+func (g *Gateway) userStatus(userID int) string {
+	clients := g.users[userID]
+	if len(clients) == 0 {
+		return statusOffline
+	}
+	for c := range clients {
+		if !c.away {
+			return statusOnline
+		}
+	}
+	return statusAway
+}
+
+// setAway marks one socket as away or back, the user's followers hear about it
+// when it changes the user's status
+func (g *Gateway) setAway(c *Client, away bool) {
+	g.mu.Lock()
+	before := g.userStatus(c.userID)
+	c.away = away
+	after := g.userStatus(c.userID)
+	g.mu.Unlock()
+
+	if before != after {
+		g.presenceChanged(c.userID, after)
+	}
+}
+
+// presence returns the current presence of a user
+func (g *Gateway) presence(userID int, lastSeenAt *time.Time) Presence {
+	g.mu.RLock()
+	status := g.userStatus(userID)
+	g.mu.RUnlock()
+
+	p := Presence{UserID: userID, Status: status}
+	if status == statusOffline {
+		p.LastSeenAt = lastSeenAt
+	}
+	return p
+}
+
+// presenceChanged records when a user goes offline and tells their followers,
+// unless the user hides their presence
+func (g *Gateway) presenceChanged(userID int, status string) {
+	p := Presence{UserID: userID, Status: status}
+	if status == statusOffline {
+		now := time.Now().UTC()
+		if err := models.Db.UpdateLastSeen(userID, now); err != nil {
+			log.Println("failed to update last seen:", err)
+		}
+		p.LastSeenAt = &now
+	}
+
+	hidden, err := models.Db.IsPresenceHidden(userID)
+	if err != nil {
+		log.Println("failed to read presence settings:", err)
+		return
+	}
+	if hidden {
+		return
+	}
+	g.publishPresence(p)
+}
+
+// publishPresence pushes a presence to the followers of its user
+func (g *Gateway) publishPresence(p Presence) {
+	followers, err := models.Db.GetFollowerIDs(p.UserID)
+	if err != nil {
+		log.Println("failed to load followers:", err)
+		return
+	}
+	for _, followerID := range followers {
+		g.PublishToUser(followerID, topicPresence, "presence", p)
+	}
+}
+
+// handlePresence lets a client say it is away, like when its window is in the background
+func (g *Gateway) handlePresence(c *Client, env Envelope) error {
+	var payload presencePayload
+	if err := json.Unmarshal(env.Data, &payload); err != nil {
+		return &topicError{Code: "invalid_presence", Message: "status must be online or away"}
+	}
+	switch payload.Status {
+	case statusOnline:
+		g.setAway(c, false)
+	case statusAway:
+		g.setAway(c, true)
+	default:
+		return &topicError{Code: "invalid_presence", Message: "status must be online or away"}
+	}
+	return nil
+}
+
+// handleTyping tells the other members of a conversation that the client is typing.
+// Nothing is stored, and frames coming faster than typingInterval are dropped.
+func (g *Gateway) handleTyping(c *Client, env Envelope) error {
+	if err := checkCanSend(c); err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(env.Topic, topicDirectPrefix), strings.HasPrefix(env.Topic, topicChatPrefix):
+		chatID, err := typingChatID(c, env.Topic)
+		if err != nil {
+			return err
+		}
+		if !c.allowTyping(topicChatPrefix + strconv.Itoa(chatID)) {
+			return nil
+		}
+		if err := checkNotBlocked(chatID, c.userID); err != nil {
+			return err
+		}
+
+		// people who didn't accept the chat don't see anyone typing in it
+		statuses, err := models.Db.GetChatMemberStatuses(chatID)
+		if err != nil {
+			return err
+		}
+		event := typingEvent{ChatID: chatID, UserID: c.userID}
+		for memberID, status := range statuses {
+			if memberID != c.userID && status == models.ChatMemberAccepted {
+				g.PublishToUser(memberID, topicDirect, "typing", event)
+			}
+		}
+		return nil
+
+	case strings.HasPrefix(env.Topic, topicGroupPrefix):
+		groupID, err := strconv.Atoi(strings.TrimPrefix(env.Topic, topicGroupPrefix))
+		if err != nil {
+			return errUnknownTopic
+		}
+		if err := checkGroupMember(c.userID, groupID); err != nil {
+			return err
+		}
+		topic := topicGroupPrefix + strconv.Itoa(groupID)
+		if !c.allowTyping(topic) {
+			return nil
+		}
+
+		g.publishExcept(topic, "typing", typingEvent{GroupID: groupID, UserID: c.userID}, c.userID)
+		return nil
+	}
+	return errUnknownTopic
+}
+
+// allowTyping tells whether a client may say it is typing in a conversation again, keyed
+// by the conversation's ID rather than the topic it was named by, and records it
+func (c *Client) allowTyping(conversation string) bool {
+	if last, ok := c.lastTyping[conversation]; ok && time.Since(last) < typingInterval {
+		return false
+	}
+	c.lastTyping[conversation] = time.Now()
+	return true
+}
+
+// typingChatID finds the chat of a "dm:<user id>" or "chat:<id>" topic, typing never creates one
+func typingChatID(c *Client, topic string) (int, error) {
+	notFound := &topicError{Code: "chat_not_found", Message: "chat not found"}
+
+	if strings.HasPrefix(topic, topicDirectPrefix) {
+		receiverID, err := strconv.Atoi(strings.TrimPrefix(topic, topicDirectPrefix))
+		if err != nil {
+			return 0, errUnknownTopic
+		}
+		chatID, err := models.Db.GetDirectChatID(c.userID, receiverID)
+		if err != nil {
+			return 0, err
+		}
+		if chatID == 0 {
+			return 0, notFound
+		}
+		return chatID, nil
+	}
+
+	chatID, err := strconv.Atoi(strings.TrimPrefix(topic, topicChatPrefix))
+	if err != nil {
+		return 0, errUnknownTopic
+	}
+	isMember, err := models.Db.IsChatMember(chatID, c.userID)
+	if err != nil {
+		return 0, err
+	}
+	if !isMember {
+		return 0, notFound
+	}
+	return chatID, nil
+}
+
+// PresenceHandler returns the presence of the users the current user follows,
+// those hiding their presence are left out
+func PresenceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	followed, err := models.Db.GetFollowedPresence(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	presence := make([]Presence, 0, len(followed))
+	for _, f := range followed {
+		if wsGateway != nil {
+			presence = append(presence, wsGateway.presence(f.UserID, f.LastSeenAt))
+		} else {
+			presence = append(presence, Presence{UserID: f.UserID, Status: statusOffline, LastSeenAt: f.LastSeenAt})
+		}
+	}
+	tools.JSONResponse(w, http.StatusOK, presence)
+}
+
+// PresenceSettingsHandler reads (GET) or changes (PUT) whether the current user hides their presence
+func PresenceSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(int)
+
+	switch r.Method {
+	case http.MethodGet:
+		hidden, err := models.Db.IsPresenceHidden(userID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		tools.JSONResponse(w, http.StatusOK, PresenceSettings{Hidden: hidden})
+
+	case http.MethodPut:
+		var req PresenceSettings
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := models.Db.SetPresenceHidden(userID, req.Hidden); err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		// followers see a user who hides their presence go offline, and come back when they show it again
+		if wsGateway != nil {
+			p := wsGateway.presence(userID, nil)
+			if req.Hidden {
+				p = Presence{UserID: userID, Status: statusOffline}
+			}
+			wsGateway.publishPresence(p)
+		}
+		tools.JSONResponse(w, http.StatusOK, req)
+
+	default:
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
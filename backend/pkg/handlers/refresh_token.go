@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"social-network/pkg/tools"
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshTokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// RefreshToken trades a refresh token, from the body or its cookie, for a new token pair
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req RefreshTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie("refresh_token"); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+	if req.RefreshToken == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "missing refresh token")
+		return
+	}
+
+	tokens, err := tools.RefreshTokenPair(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, tools.ErrRefreshTokenReused) {
+			tools.ErrorCodeJSONResponse(w, http.StatusUnauthorized, "refresh_token_reused", err.Error())
+			return
+		}
+		if errors.Is(err, tools.ErrInvalidRefreshToken) {
+			tools.ErrorCodeJSONResponse(w, http.StatusUnauthorized, "invalid_refresh_token", err.Error())
+			return
+		}
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	setTokenCookies(w, tokens)
+
+	apiResponse := RefreshTokenResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}
+
+	tools.JSONResponse(w, http.StatusOK, apiResponse)
+}
@@ -11,6 +11,8 @@ import (
 
 	"social-network/pkg/models"
 	"social-network/pkg/tools"
+
+	"github.com/google/uuid"
 )
 
 type RegisterResponseApi struct {
@@ -67,8 +69,7 @@ func Register(w http.ResponseWriter, r *http.Request) {
 
 		}
 
-		rand, _ := tools.GenerateJWTToken(user.ID, user.Email)
-		name := rand + extension
+		name := uuid.New().String() + extension
 		Path := "./uploads/avatars/" + name
 		out, err := os.Create(Path)
 		if err != nil {
@@ -102,6 +103,11 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the account stays restricted until the link in this email is opened
+	if err := tools.SendVerificationEmail(userID); err != nil {
+		fmt.Println("failed to send verification email:", err)
+	}
+
 	apiResponse := RegisterResponseApi{
 		Message: fmt.Sprintf("Success, User ID: %d", userID),
 	}
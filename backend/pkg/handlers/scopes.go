@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+// routeScope is what a personal access token needs on a route, Read for safe
+// methods and Write for the others. An empty scope denies that side of the route.
+type routeScope struct {
+	Read  string
+	Write string
+}
+
+// anyToken marks a route every personal access token may use, whatever its scopes
+const anyToken = "*"
+
+// routeScopes is keyed by the pattern the route is registered with. Routes that
+// aren't listed, like sessions, account settings or the tokens themselves, need a session.
+var routeScopes = map[string]routeScope{
+	"/api/user": {Read: anyToken},
+
+	"/api/posts":             {Read: "posts:read", Write: "posts:write"},
+	"/api/posts/":            {Read: "posts:read", Write: "posts:write"},
+	"/api/upload/post-image": {Write: "posts:write"},
+
+	"/api/groups":                     {Read: "groups:read", Write: "groups:manage"},
+	"/api/groups/":                    {Read: "groups:read", Write: "groups:manage"},
+	"/api/groups/invite":              {Write: "groups:manage"},
+	"/api/groups/invitation/response": {Write: "groups:manage"},
+	"/api/groups/request":             {Write: "groups:manage"},
+	"/api/groups/events":              {Read: "groups:read", Write: "groups:manage"},
+	"/api/groups/events/response":     {Write: "groups:manage"},
+
+	"/api/chats":                                              {Read: "messages:read", Write: "messages:send"},
+	"/api/chats/{id}":                                         {Read: "messages:read"},
+	"/api/chats/{id}/messages":                                {Read: "messages:read", Write: "messages:send"},
+	"/api/chats/{id}/members":                                 {Write: "messages:send"},
+	"/api/chats/{id}/messages/{messageID}":                    {Write: "messages:send"},
+	"/api/chats/{id}/messages/{messageID}/edits":              {Read: "messages:read"},
+	"/api/chats/{id}/messages/{messageID}/reactions/{emoji}":  {Write: "messages:send"},
+	"/api/chats/{id}/read":                                    {Write: "messages:read"},
+	"/api/chats/unread":                                       {Read: "messages:read"},
+	"/api/chats/requests":                                     {Read: "messages:read"},
+	"/api/chats/{id}/accept":                                  {Write: "messages:send"},
+	"/api/chats/{id}/decline":                                 {Write: "messages:send"},
+	"/api/presence":                                           {Read: "messages:read"},
+	"/api/ws/ticket":                                          {Write: "messages:read"},
+	"/api/messages":                                           {Read: "messages:read"},
+	"/api/groups/{id}/retention":                              {Read: "groups:read", Write: "groups:manage"},
+	"/api/groups/messages":                                    {Read: "messages:read"},
+	"/api/groups/{id}/messages/{messageID}":                   {Write: "messages:send"},
+	"/api/groups/{id}/messages/{messageID}/edits":             {Read: "messages:read"},
+	"/api/groups/{id}/messages/{messageID}/reactions/{emoji}": {Write: "messages:send"},
+	"/api/groups/chat":                                        {Write: "messages:send"},
+	"/api/attachments":                                        {Write: "messages:send"},
+	"/api/attachments/{id}":                                   {Read: "messages:read"},
+	"/api/attachments/{id}/thumbnail":                         {Read: "messages:read"},
+	"/api/search/messages":                                    {Read: "messages:read"},
+
+	"/api/notifications":           {Read: "notifications:read"},
+	"/api/notifications/read":      {Write: "notifications:write"},
+	"/api/notifications/unread":    {Write: "notifications:write"},
+	"/api/notifications/archive":   {Write: "notifications:write"},
+	"/api/notifications/unarchive": {Write: "notifications:write"},
+	"/api/notifications/delete":    {Write: "notifications:write"},
+	"/api/notifications/{id}":      {Write: "notifications:write"},
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// checkScope answers 403 when a personal access token wasn't granted what the route needs
+func checkScope(w http.ResponseWriter, r *http.Request, token *models.APIToken) bool {
+	route, ok := routeScopes[r.Pattern]
+	if !ok {
+		tools.ErrorCodeJSONResponse(w, http.StatusForbidden, "session_required", "this endpoint can't be used with an api token")
+		return false
+	}
+
+	scope := route.Write
+	if isSafeMethod(r.Method) {
+		scope = route.Read
+	}
+	if scope == anyToken {
+		return true
+	}
+	if scope == "" {
+		tools.ErrorCodeJSONResponse(w, http.StatusForbidden, "session_required", "this endpoint can't be used with an api token")
+		return false
+	}
+	if !token.HasScope(scope) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+		tools.ErrorCodeJSONResponse(w, http.StatusForbidden, "insufficient_scope", fmt.Sprintf("this token needs the %s scope", scope))
+		return false
+	}
+	return true
+}
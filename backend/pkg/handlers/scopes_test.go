@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"social-network/pkg/models"
+)
+
+func TestCheckScope(t *testing.T) {
+	token := &models.APIToken{Scopes: []string{"posts:read", "messages:send"}}
+	tests := []struct {
+		method  string
+		pattern string
+		allowed bool
+		code    string
+	}{
+		{http.MethodGet, "/api/user", true, ""},
+		{http.MethodPost, "/api/user", false, "session_required"},
+		{http.MethodGet, "/api/posts", true, ""},
+		{http.MethodHead, "/api/posts", true, ""},
+		{http.MethodPost, "/api/posts", false, "insufficient_scope"},
+		{http.MethodGet, "/api/chats/{id}/messages", false, "insufficient_scope"},
+		{http.MethodPost, "/api/chats/{id}/messages", true, ""},
+		{http.MethodDelete, "/api/chats/{id}/messages/{messageID}", true, ""},
+		{http.MethodGet, "/api/chats/{id}/messages/{messageID}", false, "session_required"},
+		{http.MethodGet, "/api/notifications", false, "insufficient_scope"},
+		{http.MethodPost, "/api/tokens", false, "session_required"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		r.Pattern = tt.pattern
+		w := httptest.NewRecorder()
+
+		if got := checkScope(w, r, token); got != tt.allowed {
+			t.Errorf("%s %s: checkScope = %v, want %v", tt.method, tt.pattern, got, tt.allowed)
+			continue
+		}
+		if tt.allowed {
+			continue
+		}
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.pattern, w.Code, http.StatusForbidden)
+		}
+		if !strings.Contains(w.Body.String(), `"`+tt.code+`"`) {
+			t.Errorf("%s %s: body %s, want code %s", tt.method, tt.pattern, w.Body.String(), tt.code)
+		}
+	}
+}
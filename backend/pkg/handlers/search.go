@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	maxSearchLength    = 200
+)
+
+// SearchPage is a page of search results, the next one starts at offset + len(results)
+type SearchPage struct {
+	Results []models.SearchResult `json:"results"`
+	HasMore bool                  `json:"has_more"`
+}
+
+// SearchMessagesHandler searches the chats and groups of the current user, the latest
+// messages first. Snippets are HTML with the matched words in <mark>, and a result is
+// opened with ?around=<message_id> on the history of its chat or group.
+func SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID := r.Context().Value("userID").(int)
+
+	q, ok := searchQueryFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// searching one conversation is only allowed to its members
+	if q.ChatID > 0 {
+		isMember, err := models.Db.IsChatMember(q.ChatID, userID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		if !isMember {
+			tools.ErrorJSONResponse(w, http.StatusNotFound, "chat not found")
+			return
+		}
+	}
+	if q.GroupID > 0 {
+		if err := checkGroupMember(userID, q.GroupID); err != nil {
+			if _, ok := err.(*topicError); ok {
+				tools.ErrorJSONResponse(w, http.StatusNotFound, "group not found")
+				return
+			}
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+
+	results, hasMore, err := models.Db.SearchMessages(userID, q)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	for i := range results {
+		results[i].Snippet = highlightSnippet(results[i].Snippet)
+	}
+	tools.JSONResponse(w, http.StatusOK, SearchPage{Results: results, HasMore: hasMore})
+}
+
+// searchQueryFromRequest reads ?q= and the filters of a search: ?chat_id= or ?group_id=,
+// ?sender_id=, ?from= and ?to= as dates or RFC 3339 times, ?limit= and ?offset=
+func searchQueryFromRequest(w http.ResponseWriter, r *http.Request) (models.SearchQuery, bool) {
+	query := r.URL.Query()
+	q := models.SearchQuery{Text: strings.TrimSpace(query.Get("q")), Limit: defaultSearchLimit}
+
+	if q.Text == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "q is required")
+		return q, false
+	}
+	if utf8.RuneCountInString(q.Text) > maxSearchLength {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("q must be at most %d characters", maxSearchLength))
+		return q, false
+	}
+
+	for name, target := range map[string]*int{"chat_id": &q.ChatID, "group_id": &q.GroupID, "sender_id": &q.SenderID} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, name+" must be an id")
+			return q, false
+		}
+		*target = id
+	}
+	if q.ChatID > 0 && q.GroupID > 0 {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "use only one of chat_id and group_id")
+		return q, false
+	}
+
+	for name, target := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			// a plain date covers the whole day, ?to= included
+			t, err = time.Parse(time.DateOnly, value)
+			if err != nil {
+				tools.ErrorJSONResponse(w, http.StatusBadRequest, name+" must be a date or an RFC 3339 time")
+				return q, false
+			}
+			if name == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		*target = t
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "from must be before to")
+		return q, false
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "limit must be a positive number")
+			return q, false
+		}
+		q.Limit = min(limit, maxSearchLimit)
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			tools.ErrorJSONResponse(w, http.StatusBadRequest, "offset must be a positive number")
+			return q, false
+		}
+		q.Offset = offset
+	}
+	return q, true
+}
+
+// highlightSnippet escapes a snippet for HTML and puts its matched words in <mark>
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, models.SnippetStart, "<mark>")
+	return strings.ReplaceAll(escaped, models.SnippetEnd, "</mark>")
+}
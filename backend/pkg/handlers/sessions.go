@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+type SessionResponse struct {
+	models.Token
+	Current bool `json:"current"`
+}
+
+type SessionsResponse struct {
+	Message  string            `json:"message,omitempty"`
+	Sessions []SessionResponse `json:"sessions,omitempty"`
+	Revoked  int               `json:"revoked,omitempty"`
+}
+
+// SessionsHandler lists the sessions of the current user (GET)
+// or logs out every session except the current one (DELETE)
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(int)
+	tokenID, _ := r.Context().Value("tokenID").(string)
+
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := models.Db.GetUserTokens(userID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		sessions := []SessionResponse{}
+		for _, t := range tokens {
+			sessions = append(sessions, SessionResponse{Token: t, Current: t.TokenID == tokenID})
+		}
+		tools.JSONResponse(w, http.StatusOK, SessionsResponse{Sessions: sessions})
+
+	case http.MethodDelete:
+		revoked, err := models.Db.DeleteUserTokensExcept(userID, tokenID)
+		if err != nil {
+			fmt.Println(err)
+			tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		for _, sessionID := range revoked {
+			disconnectSession(sessionID)
+		}
+		tools.JSONResponse(w, http.StatusOK, SessionsResponse{
+			Message: "logged out of every other session",
+			Revoked: len(revoked),
+		})
+
+	default:
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// SessionHandler revokes one session of the current user
+func SessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "expected session id")
+		return
+	}
+
+	session, err := models.Db.GetTokenByID(id)
+	if err != nil || session.UserID != userID {
+		tools.ErrorJSONResponse(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	if err := revokeSession(session.TokenID); err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, SessionsResponse{Message: "session revoked"})
+}
+
+// revokeSession deletes a session and kicks its live sockets
+func revokeSession(tokenID string) error {
+	if err := models.Db.DeleteToken(tokenID); err != nil {
+		return err
+	}
+	disconnectSession(tokenID)
+	return nil
+}
+
+func disconnectSession(tokenID string) {
+	if wsGateway != nil {
+		wsGateway.DisconnectSession(tokenID)
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type TwoFactorStatusResponse struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type RecoveryCodesResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginTwoFactor is the second step of a login, it trades a challenge and a code for a session
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.ChallengeToken == "" || req.Code == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "please fill all the fields")
+		return
+	}
+
+	_, ip := tools.ClientInfo(r)
+	userID, err := tools.CompleteLoginChallenge(req.ChallengeToken, req.Code, ip)
+	if err != nil {
+		var locked *tools.LoginLockedError
+		if errors.As(err, &locked) {
+			loginLocked(w, locked)
+			return
+		}
+		twoFactorError(w, err)
+		return
+	}
+
+	user, err := models.Db.GetUserByID(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	startSession(w, r, user)
+}
+
+// TwoFactorHandler reports whether the current user has two-factor authentication on
+func TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	enabled, err := tools.IsTwoFactorEnabled(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	left, err := models.Db.CountRecoveryCodes(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, TwoFactorStatusResponse{Enabled: enabled, RecoveryCodesLeft: left})
+}
+
+// SetupTwoFactor generates a secret and the otpauth:// URI to show as a QR code
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	user, err := models.Db.GetUserByID(userID)
+	if err != nil {
+		tools.ErrorJSONResponse(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	setup, err := tools.BeginTwoFactorSetup(user)
+	if err != nil {
+		twoFactorError(w, err)
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, setup)
+}
+
+// ConfirmTwoFactor enables two-factor authentication with a first code from the app
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	codes, err := tools.ConfirmTwoFactor(userID, req.Code)
+	if err != nil {
+		twoFactorError(w, err)
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, RecoveryCodesResponse{
+		Message:       "two-factor authentication enabled, keep these recovery codes somewhere safe",
+		RecoveryCodes: codes,
+	})
+}
+
+// DisableTwoFactor turns two-factor authentication off, it needs a valid code: the password
+// alone isn't enough, it is what the second factor protects
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	if ok := checkAccountCode(w, r, userID, req.Code); !ok {
+		return
+	}
+
+	if err := tools.DisableTwoFactor(userID); err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, AccountResponse{Message: "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, it needs a valid code
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		tools.ErrorJSONResponse(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	if ok := checkAccountCode(w, r, userID, req.Code); !ok {
+		return
+	}
+
+	codes, err := tools.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, RecoveryCodesResponse{
+		Message:       "new recovery codes generated, the old ones no longer work",
+		RecoveryCodes: codes,
+	})
+}
+
+// checkAccountCode accepts a TOTP or recovery code of the current user, wrong ones count
+// towards the lockout of the account
+func checkAccountCode(w http.ResponseWriter, r *http.Request, userID int, code string) bool {
+	_, ip := tools.ClientInfo(r)
+	ok, err := tools.VerifyAccountSecondFactor(userID, code, ip)
+	if err != nil {
+		twoFactorError(w, err)
+		return false
+	}
+	if !ok {
+		twoFactorError(w, tools.ErrInvalidTwoFactorCode)
+		return false
+	}
+	return true
+}
+
+func twoFactorError(w http.ResponseWriter, err error) {
+	var locked *tools.LoginLockedError
+	switch {
+	case errors.As(err, &locked):
+		loginLocked(w, locked)
+	case errors.Is(err, tools.ErrInvalidTwoFactorCode):
+		tools.ErrorCodeJSONResponse(w, http.StatusBadRequest, "invalid_code", err.Error())
+	case errors.Is(err, tools.ErrInvalidChallenge):
+		tools.ErrorCodeJSONResponse(w, http.StatusUnauthorized, "invalid_challenge", err.Error())
+	case errors.Is(err, tools.ErrTwoFactorEnabled):
+		tools.ErrorCodeJSONResponse(w, http.StatusConflict, "two_factor_enabled", err.Error())
+	case errors.Is(err, tools.ErrTwoFactorNotEnabled):
+		tools.ErrorCodeJSONResponse(w, http.StatusConflict, "two_factor_not_enabled", err.Error())
+	default:
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+	}
+}
@@ -3,12 +3,14 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"sync"
 	"time"
 
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -18,8 +20,9 @@ func InitDB(db *sql.DB) {
 	DB = db
 }
 
+// Message is a private message in the format of GetMessages, kept for older clients
 type Message struct {
-	Type           string   `json:"type"` // "messageuser", "messageGroup", "notification"
+	Type           string   `json:"type"` // "messageuser"
 	Sender         string   `json:"sender"`
 	Receivers      []string `json:"receiver"`
 	Content        string   `json:"content"`
@@ -29,142 +32,172 @@ type Message struct {
 	Timestamp      string   `json:"timestamp"`
 }
 
-type Connection struct {
-	Conn   *websocket.Conn
-	UserID string
-}
-
-type Hub struct {
-	userConnections map[string]map[*websocket.Conn]bool
-	messageChan     chan Message
-	register        chan *Connection
-	unregister      chan *Connection
-	mu              sync.Mutex
-}
-
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		userConnections: make(map[string]map[*websocket.Conn]bool),
-		messageChan:     make(chan Message),
-		register:        make(chan *Connection),
-		unregister:      make(chan *Connection),
-	}
+// clients that don't authenticate with a ticket have this long to send their auth frame
+const wsAuthTimeout = 10 * time.Second
+
+type wsAuthMessage struct {
+	Type   string `json:"type"`
+	Token  string `json:"token"`
+	Ticket string `json:"ticket"`
 }
 
-func (h *Hub) Run() {
-	for {
-		select {
-		case conn := <-h.register:
-			h.mu.Lock()
-			if h.userConnections[conn.UserID] == nil {
-				h.userConnections[conn.UserID] = make(map[*websocket.Conn]bool)
-			}
-			h.userConnections[conn.UserID][conn.Conn] = true
-			h.mu.Unlock()
-
-		case conn := <-h.unregister:
-			h.mu.Lock()
-			delete(h.userConnections[conn.UserID], conn.Conn)
-			if conns, ok := h.userConnections[conn.UserID]; ok {
-				delete(conns, conn.Conn)
-				if len(conns) == 0 {
-					delete(h.userConnections, conn.UserID)
-				}
-			}
-			h.mu.Unlock()
+type wsAuthenticated struct {
+	UserID int `json:"user_id"`
+}
 
-		case msg := <-h.messageChan:
-			h.dispatchMessage(msg)
+// HandleWebSocket serves /ws. The socket is bound to a user either by a ticket from
+// POST /api/ws/ticket passed as ?ticket=, or by a first frame {"type": "auth", "token": "..."}.
+func HandleWebSocket(g *Gateway, w http.ResponseWriter, r *http.Request) {
+	var identity *tools.SocketIdentity
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		var err error
+		identity, err = tools.AuthenticateSocket("", ticket)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
 		}
 	}
-}
 
-func SaveMessage(sender, receiver, content, timestamp string) error {
-	_, err := DB.Exec(`
-        INSERT INTO messages (sender_id, receiver_id, content, timestamp)
-        VALUES (?, ?, ?, ?)`, sender, receiver, content, timestamp)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Error saving message:", err)
+		http.Error(w, "Failed to upgrade connection", http.StatusInternalServerError)
+		return
 	}
-	return err
+
+	if identity == nil {
+		identity, err = readSocketAuth(conn)
+		if err != nil {
+			rejectSocket(conn, err.Error())
+			return
+		}
+	}
+
+	client := newClient(g, conn, identity)
+	g.register(client)
+	go client.writePump()
+
+	data, _ := json.Marshal(wsAuthenticated{UserID: client.userID})
+	client.send(Envelope{Type: "authenticated", Data: data})
+
+	client.readPump()
+	g.unregister(client)
+	client.close(false, websocket.CloseNormalClosure, "")
 }
 
-func (h *Hub) dispatchMessage(msg Message) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// handleFrame answers one frame sent by a client
+func (g *Gateway) handleFrame(c *Client, env Envelope) {
+	switch env.Type {
+	case "subscribe":
+		if err := authorizeTopic(c, env.Topic); err != nil {
+			frameError(c, env, err)
+			return
+		}
+		// without a valid "after" a notifications socket only gets the unread count
+		var sub notificationsSubscription
+		if env.Topic == topicNotifications && len(env.Data) > 0 {
+			json.Unmarshal(env.Data, &sub)
+		}
+		// a socket replaying or catching up with what it missed holds live pushes back from the start
+		replay := env.Topic == topicDirect && c.startReplay()
+		if sub.After != nil && !c.startCatchUp() {
+			sub.After = nil
+		}
+		g.subscribe(c, env.Topic)
+		c.send(Envelope{Type: "subscribed", Topic: env.Topic, ID: env.ID})
+		switch env.Topic {
+		case topicDirect:
+			if replay {
+				go g.replayUndelivered(c)
+			}
+		case topicNotifications:
+			go g.catchUpNotifications(c, sub.After)
+		}
 
-	msg.Timestamp = time.Now().Format("2006-01-02 15:04:05")
+	case "unsubscribe":
+		g.unsubscribe(c, env.Topic)
+		c.send(Envelope{Type: "unsubscribed", Topic: env.Topic, ID: env.ID})
 
-	switch msg.Type {
-	case "messageuser":
-		if len(msg.Receivers) == 0 {
+	case "send":
+		msg, err := g.handleSend(c, env)
+		if err != nil {
+			frameError(c, env, err)
 			return
 		}
-		receiverID := msg.Receivers[0]
-		fmt.Println(msg.Sender, receiverID, msg.Content, msg.Timestamp)
-		SaveMessage(msg.Sender, receiverID, msg.Content, msg.Timestamp)
+		data, _ := json.Marshal(msg)
+		c.send(Envelope{Type: "ack", Topic: env.Topic, ID: env.ID, Data: data})
 
-		if conns, ok := h.userConnections[receiverID]; ok {
-			for conn := range conns {
-				conn.WriteJSON(msg)
-			}
+	case "typing":
+		if err := g.handleTyping(c, env); err != nil {
+			frameError(c, env, err)
 		}
 
-	case "messageGroup":
-		for _, receiverID := range msg.Receivers {
-			SaveMessage(msg.Sender, receiverID, msg.Content, msg.Timestamp)
-			if conns, ok := h.userConnections[receiverID]; ok {
-				for conn := range conns {
-					conn.WriteJSON(msg)
-				}
-			}
+	case "presence":
+		if err := g.handlePresence(c, env); err != nil {
+			frameError(c, env, err)
 		}
+
+	case "ping":
+		c.send(Envelope{Type: "pong", ID: env.ID})
+
 	default:
-		log.Println("Unknown message type:", msg.Type)
+		c.sendError(env.ID, env.Topic, "invalid_frame", "Unknown message type")
 	}
 }
 
-func HandleWebSocket(h *Hub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		http.Error(w, "Failed to upgrade connection", http.StatusInternalServerError)
-		return
+// frameError reports a failed frame, internal errors are logged and hidden
+func frameError(c *Client, env Envelope, err error) {
+	var refused *topicError
+	switch {
+	case errors.As(err, &refused):
+		c.sendError(env.ID, env.Topic, refused.Code, refused.Message)
+	case errors.Is(err, errUnknownTopic):
+		c.sendError(env.ID, env.Topic, "unknown_topic", err.Error())
+	default:
+		fmt.Println(err)
+		c.sendError(env.ID, env.Topic, "internal_error", "internal server error")
 	}
+}
 
-	userID := r.URL.Query().Get("userid")
-	if userID == "" {
-		userID = "guest"
-	}
+// readSocketAuth waits for the auth frame of a socket opened without a ticket
+func readSocketAuth(conn *websocket.Conn) (*tools.SocketIdentity, error) {
+	conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	defer conn.SetReadDeadline(time.Time{})
 
-	client := &Connection{
-		Conn:   conn,
-		UserID: userID,
+	var auth wsAuthMessage
+	if err := conn.ReadJSON(&auth); err != nil || auth.Type != "auth" {
+		return nil, errors.New("authentication required")
 	}
+	return tools.AuthenticateSocket(auth.Token, auth.Ticket)
+}
 
-	h.register <- client
-	defer func() {
-		h.unregister <- client
-		conn.Close()
-	}()
+// rejectSocket closes a socket that failed to authenticate
+func rejectSocket(conn *websocket.Conn, reason string) {
+	conn.WriteJSON(Envelope{Type: "error", Code: "unauthorized", Error: reason})
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason),
+		time.Now().Add(time.Second))
+	conn.Close()
+}
 
-	for {
-		_, data, err := conn.ReadMessage()
-		if err != nil {
-			break
-		}
+// only users with a verified email may send messages
+func canSendMessages(userID int) bool {
+	verified, err := models.Db.IsEmailVerified(userID)
+	return err == nil && verified
+}
 
-		var msg Message
-		if err := json.Unmarshal(data, &msg); err != nil {
-			conn.WriteJSON(map[string]string{
-				"type":    "error",
-				"content": "Invalid message format",
-			})
-			continue
-		}
-		h.messageChan <- msg
+// GatewayMetricsHandler reports the counters of the realtime gateway to admins
+func GatewayMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if wsGateway == nil {
+		tools.JSONResponse(w, http.StatusOK, GatewayMetrics{})
+		return
 	}
+	tools.JSONResponse(w, http.StatusOK, wsGateway.Metrics())
 }
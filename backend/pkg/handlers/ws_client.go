@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"social-network/pkg/models"
+	"social-network/pkg/tools"
+)
+
+const (
+	// frames waiting to be written to one socket, a client that falls this far behind is dropped
+	sendQueueSize = 64
+	// a write that takes longer than this fails and closes the socket
+	writeWait = 10 * time.Second
+	// writes slower than this are counted in the metrics
+	slowWriteThreshold = time.Second
+	// a socket that doesn't answer pings for this long is considered gone
+	pongWait = 60 * time.Second
+	// pings are sent a bit more often than pongWait so a healthy socket never times out
+	pingPeriod = pongWait * 9 / 10
+	// largest frame accepted from a client
+	maxFrameSize = 64 << 10
+)
+
+// outboundFrame is a frame waiting in a client's queue, onWrite runs once it reached the socket
+type outboundFrame struct {
+	data    []byte
+	onWrite func()
+}
+
+var (
+	errClientClosed = errors.New("connection closed")
+	errQueueFull    = errors.New("send queue full")
+)
+
+// Client is one authenticated socket. Frames are queued by send and written by
+// the client's own writer goroutine, so a slow socket never blocks anyone else.
+type Client struct {
+	gateway   *Gateway
+	conn      *websocket.Conn
+	identity  *tools.SocketIdentity
+	userID    int
+	sessionID string
+	// topics and away are guarded by the gateway lock
+	topics map[string]bool
+	away   bool
+	// when the client last typed in each conversation, only touched by the reader
+	lastTyping map[string]time.Time
+	// chat messages arriving while the socket replays what it missed wait in held, so
+	// none is written, and marked delivered, before older replayed ones
+	replayMu  sync.Mutex
+	replaying bool
+	held      []*models.ChatMessage
+	// the same goes for notifications pushed while the socket catches up with the ones it missed
+	catchingUp        bool
+	heldNotifications []models.Notification
+
+	outbox    chan outboundFrame
+	done      chan struct{}
+	closeOnce sync.Once
+	// set once by close, read by the writer after done is closed
+	flush     bool
+	closeCode int
+	closeText string
+}
+
+func newClient(g *Gateway, conn *websocket.Conn, identity *tools.SocketIdentity) *Client {
+	return &Client{
+		gateway:    g,
+		conn:       conn,
+		identity:   identity,
+		userID:     identity.UserID,
+		sessionID:  identity.SessionID,
+		topics:     make(map[string]bool),
+		lastTyping: make(map[string]time.Time),
+		outbox:     make(chan outboundFrame, sendQueueSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// startReplay marks the client as replaying, false when it already is
+func (c *Client) startReplay() bool {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	if c.replaying {
+		return false
+	}
+	c.replaying = true
+	return true
+}
+
+// holdChatMessage keeps a live chat message for after the replay, false when the client isn't replaying
+func (c *Client) holdChatMessage(msg *models.ChatMessage) bool {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	if !c.replaying {
+		return false
+	}
+	c.held = append(c.held, msg)
+	return true
+}
+
+// takeHeld returns the messages held during the replay, and ends it once there are none left
+func (c *Client) takeHeld() []*models.ChatMessage {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	held := c.held
+	c.held = nil
+	if len(held) == 0 {
+		c.replaying = false
+	}
+	return held
+}
+
+// startCatchUp marks the client as catching up with notifications, false when it already is
+func (c *Client) startCatchUp() bool {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	if c.catchingUp {
+		return false
+	}
+	c.catchingUp = true
+	return true
+}
+
+// holdNotification keeps a live notification for after the catch-up, false when the client isn't catching up
+func (c *Client) holdNotification(notification models.Notification) bool {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	if !c.catchingUp {
+		return false
+	}
+	c.heldNotifications = append(c.heldNotifications, notification)
+	return true
+}
+
+// takeHeldNotifications returns the notifications held during the catch-up, and ends it once there are none left
+func (c *Client) takeHeldNotifications() []models.Notification {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	held := c.heldNotifications
+	c.heldNotifications = nil
+	if len(held) == 0 {
+		c.catchingUp = false
+	}
+	return held
+}
+
+// send queues a frame for the writer goroutine
+func (c *Client) send(env Envelope) error {
+	frame, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(frame)
+}
+
+func (c *Client) sendError(id, topic, code, message string) {
+	c.send(Envelope{Type: "error", ID: id, Topic: topic, Code: code, Error: message})
+}
+
+// enqueue never blocks, a client whose queue is full is disconnected
+func (c *Client) enqueue(frame []byte) error {
+	return c.enqueueFrame(outboundFrame{data: frame})
+}
+
+func (c *Client) enqueueFrame(frame outboundFrame) error {
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+
+	select {
+	case c.outbox <- frame:
+		return nil
+	default:
+		c.gateway.metrics.droppedFrames.Add(1)
+		if c.close(false, websocket.ClosePolicyViolation, "too slow to keep up") {
+			c.gateway.metrics.overflowDisconnects.Add(1)
+			log.Printf("disconnected a socket of user %d: send queue full\n", c.userID)
+		}
+		return errQueueFull
+	}
+}
+
+// close stops the client once, flush writes the frames already queued before the close frame.
+// It returns false when the client was already closing.
+func (c *Client) close(flush bool, code int, text string) bool {
+	closed := false
+	c.closeOnce.Do(func() {
+		c.flush, c.closeCode, c.closeText = flush, code, text
+		close(c.done)
+		closed = true
+	})
+	return closed
+}
+
+// writePump is the only goroutine writing to the socket, it also sends the heartbeats
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case frame := <-c.outbox:
+			if err := c.write(websocket.TextMessage, frame.data); err != nil {
+				c.close(false, websocket.CloseAbnormalClosure, "")
+				return
+			}
+			if frame.onWrite != nil {
+				go frame.onWrite()
+			}
+
+		case <-ticker.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				c.close(false, websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-c.done:
+			if c.flush {
+				c.drain()
+			}
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				c.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(c.closeCode, c.closeText),
+					time.Now().Add(writeWait))
+			}
+			return
+		}
+	}
+}
+
+// drain writes what is left in the queue, giving up at the first error
+func (c *Client) drain() {
+	for {
+		select {
+		case frame := <-c.outbox:
+			if err := c.write(websocket.TextMessage, frame.data); err != nil {
+				return
+			}
+			if frame.onWrite != nil {
+				go frame.onWrite()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *Client) write(messageType int, data []byte) error {
+	start := time.Now()
+	c.conn.SetWriteDeadline(start.Add(writeWait))
+	err := c.conn.WriteMessage(messageType, data)
+	if time.Since(start) > slowWriteThreshold {
+		c.gateway.metrics.slowWrites.Add(1)
+	}
+	if err != nil {
+		c.gateway.metrics.writeErrors.Add(1)
+	}
+	return err
+}
+
+// readPump reads frames until the socket goes away, each pong pushes the read deadline back
+func (c *Client) readPump() {
+	c.conn.SetReadLimit(maxFrameSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, frame, err := c.conn.ReadMessage()
+		if err != nil {
+			var netErr interface{ Timeout() bool }
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.gateway.metrics.heartbeatTimeouts.Add(1)
+			}
+			return
+		}
+
+		var env Envelope
+		if err := json.Unmarshal(frame, &env); err != nil {
+			c.sendError("", "", "invalid_frame", "Invalid message format")
+			continue
+		}
+		c.gateway.handleFrame(c, env)
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"social-network/pkg/tools"
+)
+
+type WSTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// WSTicketHandler issues a single-use ticket to open /ws?ticket=... with the current credentials
+func WSTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.ErrorJSONResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	userID := r.Context().Value("userID").(int)
+	tokenID, _ := r.Context().Value("tokenID").(string)
+	apiTokenID, _ := r.Context().Value("apiTokenID").(int)
+
+	ticket, err := tools.IssueWSTicket(userID, tokenID, apiTokenID)
+	if err != nil {
+		fmt.Println(err)
+		tools.ErrorJSONResponse(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	tools.JSONResponse(w, http.StatusOK, WSTicketResponse{
+		Ticket:    ticket,
+		ExpiresIn: int(tools.WSTicketLifetime.Seconds()),
+	})
+}
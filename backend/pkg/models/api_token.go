@@ -0,0 +1,148 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// APIToken is a personal access token a user creates for scripts and integrations
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the token was granted a scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertAPIToken stores the hash of a new personal access token
+func (db *DB) InsertAPIToken(userID int, name, tokenHash string, scopes []string, expiresAt *time.Time) (*APIToken, error) {
+	var expires interface{}
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	now := time.Now().UTC()
+	res, err := db.Db.Exec("INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, name, tokenHash, strings.Join(scopes, " "), expires, now)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &APIToken{ID: int(id), UserID: userID, Name: name, Scopes: scopes, ExpiresAt: expiresAt, CreatedAt: now}, nil
+}
+
+const apiTokenColumns = "id, user_id, name, scopes, expires_at, last_used_at, created_at"
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, nil
+}
+
+// GetAPITokenByHash retrieves a personal access token by its hash
+func (db *DB) GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	t, err := scanAPIToken(db.Db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("api token not found")
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetUserAPITokens lists the personal access tokens of a user, newest first
+func (db *DB) GetUserAPITokens(userID int) ([]APIToken, error) {
+	rows, err := db.Db.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, nil
+}
+
+// TouchAPIToken records the use of a token, at most once a minute to spare writes
+func (db *DB) TouchAPIToken(id int) error {
+	now := time.Now().UTC()
+	_, err := db.Db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)",
+		now, id, now.Add(-time.Minute))
+	return err
+}
+
+// DeleteAPIToken revokes a personal access token of a user, it returns false when there was none
+func (db *DB) DeleteAPIToken(userID, id int) (bool, error) {
+	res, err := db.Db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+// DeleteUserAPITokens revokes every personal access token of a user and returns their IDs
+func (db *DB) DeleteUserAPITokens(userID int) ([]int, error) {
+	rows, err := db.Db.Query("DELETE FROM api_tokens WHERE user_id = ? RETURNING id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetAPITokenByID retrieves a personal access token, to check it still exists
+func (db *DB) GetAPITokenByID(id int) (*APIToken, error) {
+	t, err := scanAPIToken(db.Db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("api token not found")
+		}
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Attachment is a file sent with a chat or group message
+type Attachment struct {
+	ID          int    `json:"id"`
+	UploaderID  int    `json:"uploader_id"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	// only known for images
+	Width        *int      `json:"width,omitempty"`
+	Height       *int      `json:"height,omitempty"`
+	HasThumbnail bool      `json:"has_thumbnail"`
+	CreatedAt    time.Time `json:"created_at"`
+	StorageKey   string    `json:"-"`
+}
+
+const attachmentColumns = "id, uploader_id, file_name, content_type, size, width, height, has_thumbnail, created_at, storage_key"
+
+func scanAttachment(row interface{ Scan(...interface{}) error }) (*Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.UploaderID, &a.FileName, &a.ContentType, &a.Size, &a.Width, &a.Height,
+		&a.HasThumbnail, &a.CreatedAt, &a.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// InsertAttachment stores an upload, not sent with any message yet
+func (db *DB) InsertAttachment(a *Attachment) error {
+	a.CreatedAt = time.Now().UTC()
+	return db.Db.QueryRow(`INSERT INTO attachments (uploader_id, file_name, content_type, size, storage_key, width, height, has_thumbnail, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		a.UploaderID, a.FileName, a.ContentType, a.Size, a.StorageKey, a.Width, a.Height, a.HasThumbnail, a.CreatedAt).Scan(&a.ID)
+}
+
+// GetUnsentAttachmentUsage returns how many uploads of a user weren't sent yet and their total size
+func (db *DB) GetUnsentAttachmentUsage(userID int) (int, int64, error) {
+	var count int
+	var size int64
+	err := db.Db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size), 0) FROM attachments
+		WHERE uploader_id = ? AND chat_message_id IS NULL AND group_message_id IS NULL`, userID).Scan(&count, &size)
+	return count, size, err
+}
+
+// GetAttachment returns an attachment as long as a user may read it: they uploaded it, or
+// it was sent with a message still visible in one of their chats or groups
+func (db *DB) GetAttachment(attachmentID, userID int) (*Attachment, error) {
+	a, err := scanAttachment(db.Db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments AS a
+		WHERE a.id = ?1 AND (a.uploader_id = ?2
+			OR EXISTS(SELECT 1 FROM messages AS m
+				JOIN chat_members AS cm ON cm.chat_id = m.chat_id AND cm.user_id = ?2
+				WHERE m.id = a.chat_message_id AND m.deleted_at IS NULL
+					AND m.id NOT IN (SELECT message_id FROM message_hidden WHERE user_id = ?2))
+			OR EXISTS(SELECT 1 FROM group_messages AS gm
+				JOIN group_members AS g ON g.group_id = gm.group_id AND g.user_id = ?2 AND g.status IN ('approved', 'creator')
+				WHERE gm.id = a.group_message_id AND gm.deleted_at IS NULL
+					AND gm.id NOT IN (SELECT message_id FROM group_message_hidden WHERE user_id = ?2)))`, attachmentID, userID))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("attachment not found")
+	}
+	return a, err
+}
+
+// attachToMessage hands uploads over to a message within its transaction. Only unsent
+// uploads of the sender can be attached.
+func attachToMessage(tx *sql.Tx, column string, messageID, senderID int, attachmentIDs []int) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+	in, args := inClause(attachmentIDs)
+	res, err := tx.Exec(`UPDATE attachments SET `+column+` = ?
+		WHERE uploader_id = ? AND chat_message_id IS NULL AND group_message_id IS NULL AND id IN `+in,
+		append([]interface{}{messageID, senderID}, args...)...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(n) != len(attachmentIDs) {
+		return errors.New("attachment not available")
+	}
+	return nil
+}
+
+// loadAttachments returns the attachments of messages by message ID, in upload order
+func (db *DB) loadAttachments(column string, messageIDs []int) (map[int][]Attachment, error) {
+	attachments := make(map[int][]Attachment)
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+	in, args := inClause(messageIDs)
+	rows, err := db.Db.Query(`SELECT `+column+`, `+attachmentColumns+` FROM attachments
+		WHERE `+column+` IN `+in+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var a Attachment
+		err := rows.Scan(&messageID, &a.ID, &a.UploaderID, &a.FileName, &a.ContentType, &a.Size, &a.Width, &a.Height,
+			&a.HasThumbnail, &a.CreatedAt, &a.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		attachments[messageID] = append(attachments[messageID], a)
+	}
+	return attachments, rows.Err()
+}
+
+// loadChatAttachments fills the attachments of chat messages, tombstones keep none
+func (db *DB) loadChatAttachments(messages []ChatMessage) error {
+	ids := make([]int, 0, len(messages))
+	for _, m := range messages {
+		if !m.Deleted {
+			ids = append(ids, m.ID)
+		}
+	}
+	attachments, err := db.loadAttachments("chat_message_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if list, ok := attachments[messages[i].ID]; ok {
+			messages[i].Attachments = list
+		}
+	}
+	return nil
+}
+
+// loadGroupAttachments fills the attachments of group messages
+func (db *DB) loadGroupAttachments(messages []GroupMessage) error {
+	ids := make([]int, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	attachments, err := db.loadAttachments("group_message_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if list, ok := attachments[messages[i].ID]; ok {
+			messages[i].Attachments = list
+		}
+	}
+	return nil
+}
+
+// DeleteOrphanAttachments deletes the uploads never sent and created before staleBefore,
+// and the attachments whose message was deleted or purged. It returns the storage keys
+// of the deleted attachments so their files can go too.
+func (db *DB) DeleteOrphanAttachments(staleBefore time.Time) ([]string, error) {
+	rows, err := db.Db.Query(`DELETE FROM attachments
+		WHERE (chat_message_id IS NULL AND group_message_id IS NULL AND created_at < ?)
+			OR (chat_message_id IS NOT NULL AND chat_message_id NOT IN (SELECT id FROM messages WHERE deleted_at IS NULL))
+			OR (group_message_id IS NOT NULL AND group_message_id NOT IN (SELECT id FROM group_messages WHERE deleted_at IS NULL))
+		RETURNING storage_key`, staleBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"time"
+)
+
+// BlockedUser is someone a user blocked
+type BlockedUser struct {
+	UserID    int       `json:"user_id"`
+	Firstname string    `json:"firstname"`
+	Lastname  string    `json:"lastname"`
+	Nickname  string    `json:"nickname"`
+	Avatar    *string   `json:"avatar"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+// BlockUser stops two users from messaging or following each other, the follows
+// between them in both directions are dropped
+func (db *DB) BlockUser(blockerID, blockedID int) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id, created_at) VALUES (?, ?, ?)",
+		blockerID, blockedID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM follow_requests
+		WHERE (follower_id = ?1 AND following_id = ?2) OR (follower_id = ?2 AND following_id = ?1)`, blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnblockUser lifts a block, false when there was none
+func (db *DB) UnblockUser(blockerID, blockedID int) (bool, error) {
+	res, err := db.Db.Exec("DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?", blockerID, blockedID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// IsBlockedBetween reports whether either of two users blocked the other
+func (db *DB) IsBlockedBetween(userA, userB int) (bool, error) {
+	var blocked bool
+	err := db.Db.QueryRow(`SELECT EXISTS(SELECT 1 FROM user_blocks
+		WHERE (blocker_id = ?1 AND blocked_id = ?2) OR (blocker_id = ?2 AND blocked_id = ?1))`, userA, userB).Scan(&blocked)
+	return blocked, err
+}
+
+// IsBlockedWithAny reports whether one of userIDs blocked one of others or was blocked by them
+func (db *DB) IsBlockedWithAny(userIDs, others []int) (bool, error) {
+	if len(userIDs) == 0 || len(others) == 0 {
+		return false, nil
+	}
+	users, userArgs := inClause(userIDs)
+	in, otherArgs := inClause(others)
+	args := append(append(append(append([]interface{}{}, userArgs...), otherArgs...), otherArgs...), userArgs...)
+	var blocked bool
+	err := db.Db.QueryRow(`SELECT EXISTS(SELECT 1 FROM user_blocks
+		WHERE (blocker_id IN `+users+` AND blocked_id IN `+in+`) OR (blocker_id IN `+in+` AND blocked_id IN `+users+`))`, args...).Scan(&blocked)
+	return blocked, err
+}
+
+// GetBlockedChatMembers returns the members of a chat of whom one blocked userID or was blocked by them
+func (db *DB) GetBlockedChatMembers(chatID, userID int) (map[int]bool, error) {
+	rows, err := db.Db.Query(`SELECT cm.user_id FROM chat_members AS cm
+		JOIN user_blocks AS b ON (b.blocker_id = cm.user_id AND b.blocked_id = ?2) OR (b.blocker_id = ?2 AND b.blocked_id = cm.user_id)
+		WHERE cm.chat_id = ?1 AND cm.user_id != ?2`, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := make(map[int]bool)
+	for rows.Next() {
+		var memberID int
+		if err := rows.Scan(&memberID); err != nil {
+			return nil, err
+		}
+		blocked[memberID] = true
+	}
+	return blocked, rows.Err()
+}
+
+// GetBlockedUsers lists the users someone blocked, the latest first
+func (db *DB) GetBlockedUsers(blockerID int) ([]BlockedUser, error) {
+	rows, err := db.Db.Query(`
+		SELECT u.id, u.first_name, u.last_name, COALESCE(u.nickname, ''), u.avatar, b.created_at
+		FROM user_blocks AS b
+		JOIN users AS u ON u.id = b.blocked_id
+		WHERE b.blocker_id = ?
+		ORDER BY b.created_at DESC, u.id DESC`, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := []BlockedUser{}
+	for rows.Next() {
+		var b BlockedUser
+		if err := rows.Scan(&b.UserID, &b.Firstname, &b.Lastname, &b.Nickname, &b.Avatar, &b.BlockedAt); err != nil {
+			return nil, err
+		}
+		blocked = append(blocked, b)
+	}
+	return blocked, rows.Err()
+}
+
+// CanMessageDirectly reports whether a user reaches someone's inbox without a message
+// request: the target has a public profile, or one of them follows the other
+func (db *DB) CanMessageDirectly(senderID, targetID int) (bool, error) {
+	var allowed bool
+	err := db.Db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?2 AND is_public = 1)
+		OR EXISTS(SELECT 1 FROM follow_requests WHERE status = 'approved'
+			AND ((follower_id = ?1 AND following_id = ?2) OR (follower_id = ?2 AND following_id = ?1)))`,
+		senderID, targetID).Scan(&allowed)
+	return allowed, err
+}
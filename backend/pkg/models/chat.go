@@ -0,0 +1,511 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the status of a chat member. A pending member got the chat as a message request,
+// a declined one turned it down and doesn't see it anymore.
+const (
+	ChatMemberAccepted = "accepted"
+	ChatMemberPending  = "pending"
+	ChatMemberDeclined = "declined"
+)
+
+// Chat is a conversation between two users, or a named thread between several
+type Chat struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	IsGroup     bool         `json:"is_group"`
+	Members     []ChatMember `json:"members"`
+	LastMessage *ChatMessage `json:"last_message"`
+	// messages from the others the viewing user hasn't read yet
+	UnreadCount int `json:"unread_count"`
+	// the viewing user's membership, pending while the chat is a message request for them
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ChatMember struct {
+	UserID    int     `json:"user_id"`
+	Firstname string  `json:"firstname"`
+	Lastname  string  `json:"lastname"`
+	Nickname  string  `json:"nickname"`
+	Avatar    *string `json:"avatar"`
+	// the member has seen every message up to this one, nil before they open the chat
+	LastReadMessageID *int `json:"last_read_message_id"`
+	// the member's sockets received every message up to this one
+	LastDeliveredMessageID *int `json:"last_delivered_message_id"`
+}
+
+// DeliveryReceipt tells that the sockets of a member received a chat up to a message
+type DeliveryReceipt struct {
+	ChatID                 int `json:"chat_id"`
+	UserID                 int `json:"user_id"`
+	LastDeliveredMessageID int `json:"last_delivered_message_id"`
+}
+
+// ReadReceipt tells that a member has read a chat up to a message
+type ReadReceipt struct {
+	ChatID            int `json:"chat_id"`
+	UserID            int `json:"user_id"`
+	LastReadMessageID int `json:"last_read_message_id"`
+}
+
+type ChatMessage struct {
+	ID       int `json:"id"`
+	ChatID   int `json:"chat_id"`
+	SenderID int `json:"sender_id"`
+	// the idempotency ID given by the sender's client, if any
+	ClientID  *string    `json:"client_id,omitempty"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+	// a message deleted for everyone is kept as a tombstone, without content or reactions
+	Deleted     bool              `json:"deleted"`
+	Reactions   []MessageReaction `json:"reactions"`
+	Attachments []Attachment      `json:"attachments"`
+}
+
+func directKey(userA, userB int) string {
+	if userA > userB {
+		userA, userB = userB, userA
+	}
+	return fmt.Sprintf("%d:%d", userA, userB)
+}
+
+// GetDirectChatID returns the 1:1 chat between two users, 0 when they never talked
+func (db *DB) GetDirectChatID(userA, userB int) (int, error) {
+	var id int
+	err := db.Db.QueryRow("SELECT id FROM chats WHERE direct_key = ?", directKey(userA, userB)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// GetOrCreateDirectChat returns the 1:1 chat between two users, creating it on first use.
+// userA is the one acting, the chat is accepted for them. When request is set, a chat
+// created now is a message request for userB.
+func (db *DB) GetOrCreateDirectChat(userA, userB int, request bool) (int, error) {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// the unique direct_key makes concurrent creations collapse into one chat
+	_, err = tx.Exec("INSERT OR IGNORE INTO chats (is_group, direct_key, created_by, created_at) VALUES (0, ?, ?, ?)",
+		directKey(userA, userB), userA, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	if err := tx.QueryRow("SELECT id FROM chats WHERE direct_key = ?", directKey(userA, userB)).Scan(&id); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`INSERT INTO chat_members (chat_id, user_id, status) VALUES (?, ?, ?)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET status = excluded.status`, id, userA, ChatMemberAccepted)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT OR IGNORE INTO chat_members (chat_id, user_id, status) VALUES (?, ?, ?)", id, userB, memberStatus(request)); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func memberStatus(request bool) string {
+	if request {
+		return ChatMemberPending
+	}
+	return ChatMemberAccepted
+}
+
+// CreateGroupChat starts a named thread between a user and several others, the thread
+// is a message request for the members in requests
+func (db *DB) CreateGroupChat(creatorID int, name string, memberIDs []int, requests map[int]bool) (int, error) {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO chats (name, is_group, created_by, created_at) VALUES (?, 1, ?, ?)",
+		name, creatorID, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, userID := range append([]int{creatorID}, memberIDs...) {
+		_, err := tx.Exec("INSERT OR IGNORE INTO chat_members (chat_id, user_id, status) VALUES (?, ?, ?)",
+			id, userID, memberStatus(requests[userID]))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(id), tx.Commit()
+}
+
+// AddChatMembers adds users to a thread, members already in it are skipped, and the
+// thread is a message request for those in requests. The history from before they
+// joined isn't replayed to their sockets.
+func (db *DB) AddChatMembers(chatID int, userIDs []int, requests map[int]bool) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, userID := range userIDs {
+		_, err := tx.Exec(`INSERT OR IGNORE INTO chat_members (chat_id, user_id, status, last_delivered_message_id)
+			VALUES (?1, ?2, ?3, (SELECT MAX(id) FROM messages WHERE chat_id = ?1))`, chatID, userID, memberStatus(requests[userID]))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// IsChatMember reports whether a user takes part in a chat
+func (db *DB) IsChatMember(chatID, userID int) (bool, error) {
+	var exists bool
+	err := db.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM chat_members WHERE chat_id = ? AND user_id = ?)", chatID, userID).Scan(&exists)
+	return exists, err
+}
+
+// GetChatMemberStatuses returns the status of every member of a chat by user ID
+func (db *DB) GetChatMemberStatuses(chatID int) (map[int]string, error) {
+	rows, err := db.Db.Query("SELECT user_id, status FROM chat_members WHERE chat_id = ?", chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[int]string)
+	for rows.Next() {
+		var userID int
+		var status string
+		if err := rows.Scan(&userID, &status); err != nil {
+			return nil, err
+		}
+		statuses[userID] = status
+	}
+	return statuses, rows.Err()
+}
+
+// SetChatMemberStatus accepts or declines a chat for one of its members
+func (db *DB) SetChatMemberStatus(chatID, userID int, status string) error {
+	_, err := db.Db.Exec("UPDATE chat_members SET status = ? WHERE chat_id = ? AND user_id = ?", status, chatID, userID)
+	return err
+}
+
+// GetChatMemberIDs lists the users taking part in a chat
+func (db *DB) GetChatMemberIDs(chatID int) ([]int, error) {
+	rows, err := db.Db.Query("SELECT user_id FROM chat_members WHERE chat_id = ? ORDER BY user_id", chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// InsertChatMessage stores a message in a chat with the sender's uploads in attachmentIDs.
+// When the sender already sent a message with the same client ID, nothing is stored and
+// that message is returned with true.
+func (db *DB) InsertChatMessage(chatID, senderID int, content, clientID string, attachmentIDs []int) (*ChatMessage, bool, error) {
+	msg := &ChatMessage{ChatID: chatID, SenderID: senderID, Content: content, CreatedAt: time.Now().UTC(), Reactions: []MessageReaction{}, Attachments: []Attachment{}}
+	var clientIDValue interface{}
+	if clientID != "" {
+		msg.ClientID = &clientID
+		clientIDValue = clientID
+	}
+
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO messages (chat_id, sender_id, content, created_at, client_id) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (sender_id, client_id) WHERE client_id IS NOT NULL DO NOTHING
+		RETURNING id`,
+		chatID, senderID, content, msg.CreatedAt, clientIDValue).Scan(&msg.ID)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		existing, err := scanChatMessage(db.Db.QueryRow("SELECT "+chatMessageColumns+" FROM messages WHERE sender_id = ? AND client_id = ?",
+			senderID, clientID))
+		if err != nil {
+			return nil, false, err
+		}
+		if existing.ChatID != chatID {
+			return nil, false, errors.New("client id already used")
+		}
+		messages := []ChatMessage{*existing}
+		if err := db.loadMessageDetails(messages); err != nil {
+			return nil, false, err
+		}
+		return &messages[0], true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := attachToMessage(tx, "chat_message_id", msg.ID, senderID, attachmentIDs); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	messages := []ChatMessage{*msg}
+	if err := db.loadChatAttachments(messages); err != nil {
+		return nil, false, err
+	}
+	return &messages[0], false, nil
+}
+
+// ChatMessagePage is a window of a chat, oldest first
+type ChatMessagePage struct {
+	Messages []ChatMessage `json:"messages"`
+	PageInfo
+}
+
+// GetChatMessages returns a window of a chat without the messages the viewer deleted for themselves
+func (db *DB) GetChatMessages(chatID, viewerID int, q PageQuery) (*ChatMessagePage, error) {
+	ids, info, err := db.pageIDs("messages",
+		"chat_id = ? AND id NOT IN (SELECT message_id FROM message_hidden WHERE user_id = ?)",
+		[]interface{}{chatID, viewerID}, q)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ChatMessagePage{Messages: []ChatMessage{}, PageInfo: info}
+	if len(ids) == 0 {
+		return page, nil
+	}
+	in, args := inClause(ids)
+	rows, err := db.Db.Query("SELECT "+chatMessageColumns+" FROM messages WHERE id IN "+in+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Messages = append(page.Messages, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return page, db.loadMessageDetails(page.Messages)
+}
+
+// MarkChatRead moves the read position of a member up to a message of the chat,
+// what was read was delivered too. It never moves back, false means the member had
+// already read that far.
+func (db *DB) MarkChatRead(chatID, userID, messageID int) (bool, error) {
+	res, err := db.Db.Exec(`UPDATE chat_members SET last_read_message_id = ?1,
+			last_delivered_message_id = MAX(COALESCE(last_delivered_message_id, 0), ?1)
+		WHERE chat_id = ?2 AND user_id = ?3 AND COALESCE(last_read_message_id, 0) < ?1`,
+		messageID, chatID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// MarkChatDelivered moves the delivery position of a member up to a message of the chat.
+// It never moves back, false means the member had already received that far.
+func (db *DB) MarkChatDelivered(chatID, userID, messageID int) (bool, error) {
+	res, err := db.Db.Exec(`UPDATE chat_members SET last_delivered_message_id = ?1
+		WHERE chat_id = ?2 AND user_id = ?3 AND COALESCE(last_delivered_message_id, 0) < ?1`,
+		messageID, chatID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetUndeliveredMessages returns the messages of the other members that never reached
+// the sockets of a user, oldest first from after a message ID
+func (db *DB) GetUndeliveredMessages(userID, afterID, limit int) ([]ChatMessage, error) {
+	rows, err := db.Db.Query(`
+		SELECT `+chatMessageColumnsOf("m")+` FROM messages AS m
+		JOIN chat_members AS cm ON cm.chat_id = m.chat_id AND cm.user_id = ?1 AND cm.status = 'accepted'
+		WHERE m.id > COALESCE(cm.last_delivered_message_id, 0) AND m.id > ?2 AND m.sender_id != ?1 AND m.deleted_at IS NULL
+			AND m.id NOT IN (SELECT message_id FROM message_hidden WHERE user_id = ?1)
+		ORDER BY m.id
+		LIMIT ?3`, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, db.loadMessageDetails(messages)
+}
+
+// GetLastChatMessageID returns the latest message of a chat, 0 when it is empty
+func (db *DB) GetLastChatMessageID(chatID int) (int, error) {
+	var id int
+	err := db.Db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM messages WHERE chat_id = ?", chatID).Scan(&id)
+	return id, err
+}
+
+// IsChatMessage reports whether a message belongs to a chat
+func (db *DB) IsChatMessage(chatID, messageID int) (bool, error) {
+	var exists bool
+	err := db.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM messages WHERE id = ? AND chat_id = ?)", messageID, chatID).Scan(&exists)
+	return exists, err
+}
+
+// GetUnreadMessagesCount counts the unread messages of every chat of a user, for the badge,
+// leaving out the ones they deleted for themselves
+func (db *DB) GetUnreadMessagesCount(userID int) (int, error) {
+	var count int
+	err := db.Db.QueryRow(`
+		SELECT COUNT(*) FROM chat_members AS cm
+		JOIN messages AS m ON m.chat_id = cm.chat_id
+		WHERE cm.user_id = ? AND cm.status = 'accepted' AND m.sender_id != cm.user_id
+			AND m.id > COALESCE(cm.last_read_message_id, 0) AND m.deleted_at IS NULL
+			AND m.id NOT IN (SELECT message_id FROM message_hidden WHERE user_id = cm.user_id)`,
+		userID).Scan(&count)
+	return count, err
+}
+
+// GetChat returns a chat with its members and last message, as seen by one of its members
+func (db *DB) GetChat(chatID, viewerID int) (*Chat, error) {
+	chats, err := db.queryChats(viewerID, "WHERE c.id = ?", chatID)
+	if err != nil {
+		return nil, err
+	}
+	if len(chats) == 0 {
+		return nil, errors.New("chat not found")
+	}
+	return &chats[0], nil
+}
+
+// GetUserChats lists the chats of a user, the most recently active first, message requests left out
+func (db *DB) GetUserChats(userID int) ([]Chat, error) {
+	return db.queryChats(userID, "WHERE c.id IN (SELECT chat_id FROM chat_members WHERE user_id = ? AND status = 'accepted')", userID)
+}
+
+// GetMessageRequests lists the chats a user got as message requests, the most recent first
+func (db *DB) GetMessageRequests(userID int) ([]Chat, error) {
+	return db.queryChats(userID, "WHERE c.id IN (SELECT chat_id FROM chat_members WHERE user_id = ? AND status = 'pending')", userID)
+}
+
+// queryChats loads chats with their last message and members, as seen by the viewer
+func (db *DB) queryChats(viewerID int, where string, args ...interface{}) ([]Chat, error) {
+	rows, err := db.Db.Query(`
+		SELECT c.id, COALESCE(c.name, ''), c.is_group, c.created_at,
+			m.id, m.sender_id, m.content, m.created_at, m.edited_at, m.deleted_at IS NOT NULL,
+			(SELECT COUNT(*) FROM messages AS u
+				JOIN chat_members AS cm ON cm.chat_id = u.chat_id AND cm.user_id = ?1
+				WHERE u.chat_id = c.id AND u.sender_id != cm.user_id AND u.id > COALESCE(cm.last_read_message_id, 0)
+					AND u.deleted_at IS NULL
+					AND u.id NOT IN (SELECT message_id FROM message_hidden WHERE user_id = cm.user_id)),
+			COALESCE((SELECT status FROM chat_members WHERE chat_id = c.id AND user_id = ?1), '')
+		FROM chats AS c
+		LEFT JOIN messages AS m ON m.id = (
+			SELECT MAX(id) FROM messages
+			WHERE chat_id = c.id AND id NOT IN (SELECT message_id FROM message_hidden WHERE user_id = ?1))
+		`+where+`
+		ORDER BY COALESCE(m.id, 0) DESC, c.id DESC`, append([]interface{}{viewerID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []Chat{}
+	index := make(map[int]int)
+	for rows.Next() {
+		var c Chat
+		var msgID, senderID sql.NullInt64
+		var content sql.NullString
+		var sentAt sql.NullTime
+		var editedAt *time.Time
+		var deleted sql.NullBool
+		if err := rows.Scan(&c.ID, &c.Name, &c.IsGroup, &c.CreatedAt, &msgID, &senderID, &content, &sentAt,
+			&editedAt, &deleted, &c.UnreadCount, &c.Status); err != nil {
+			return nil, err
+		}
+		if msgID.Valid {
+			// the preview comes without reactions or attachments
+			c.LastMessage = &ChatMessage{
+				ID:          int(msgID.Int64),
+				ChatID:      c.ID,
+				SenderID:    int(senderID.Int64),
+				Content:     content.String,
+				CreatedAt:   sentAt.Time,
+				EditedAt:    editedAt,
+				Deleted:     deleted.Bool,
+				Reactions:   []MessageReaction{},
+				Attachments: []Attachment{},
+			}
+		}
+		c.Members = []ChatMember{}
+		index[c.ID] = len(chats)
+		chats = append(chats, c)
+	}
+	if err := rows.Err(); err != nil || len(chats) == 0 {
+		return chats, err
+	}
+
+	ids := make([]interface{}, 0, len(chats))
+	for _, c := range chats {
+		ids = append(ids, c.ID)
+	}
+	memberRows, err := db.Db.Query(`
+		SELECT cm.chat_id, u.id, u.first_name, u.last_name, COALESCE(u.nickname, ''), u.avatar, cm.last_read_message_id, cm.last_delivered_message_id
+		FROM chat_members AS cm
+		JOIN users AS u ON u.id = cm.user_id
+		WHERE cm.chat_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+		ORDER BY cm.chat_id, u.id`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	for memberRows.Next() {
+		var chatID int
+		var m ChatMember
+		if err := memberRows.Scan(&chatID, &m.UserID, &m.Firstname, &m.Lastname, &m.Nickname, &m.Avatar, &m.LastReadMessageID, &m.LastDeliveredMessageID); err != nil {
+			return nil, err
+		}
+		chat := &chats[index[chatID]]
+		chat.Members = append(chat.Members, m)
+	}
+	return chats, nil
+}
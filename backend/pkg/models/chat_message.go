@@ -0,0 +1,235 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var chatMessageColumns = chatMessageColumnsOf("")
+
+// chatMessageColumnsOf lists the columns read by scanChatMessage, prefixed by a table alias
+func chatMessageColumnsOf(alias string) string {
+	if alias != "" {
+		alias += "."
+	}
+	return fmt.Sprintf("%[1]sid, %[1]schat_id, %[1]ssender_id, %[1]sclient_id, %[1]scontent, %[1]screated_at, %[1]sedited_at, %[1]sdeleted_at IS NOT NULL", alias)
+}
+
+// MessageReaction is one emoji on a message with the users who reacted with it
+type MessageReaction struct {
+	Emoji   string `json:"emoji"`
+	UserIDs []int  `json:"user_ids"`
+}
+
+// MessageEdit is a previous version of an edited message
+type MessageEdit struct {
+	Content string `json:"content"`
+	// when this version was replaced
+	EditedAt time.Time `json:"edited_at"`
+}
+
+func scanChatMessage(row interface{ Scan(...interface{}) error }) (*ChatMessage, error) {
+	m := &ChatMessage{Reactions: []MessageReaction{}, Attachments: []Attachment{}}
+	err := row.Scan(&m.ID, &m.ChatID, &m.SenderID, &m.ClientID, &m.Content, &m.CreatedAt, &m.EditedAt, &m.Deleted)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetChatMessage returns one message of a chat with its reactions
+func (db *DB) GetChatMessage(chatID, messageID int) (*ChatMessage, error) {
+	m, err := scanChatMessage(db.Db.QueryRow("SELECT "+chatMessageColumns+" FROM messages WHERE id = ? AND chat_id = ?", messageID, chatID))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("message not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	messages := []ChatMessage{*m}
+	if err := db.loadMessageDetails(messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+// loadMessageDetails fills the reactions and attachments of messages
+func (db *DB) loadMessageDetails(messages []ChatMessage) error {
+	if err := db.loadReactions(messages); err != nil {
+		return err
+	}
+	return db.loadChatAttachments(messages)
+}
+
+// loadReactions fills the reactions of messages, the emoji first used comes first
+func (db *DB) loadReactions(messages []ChatMessage) error {
+	ids := make([]int, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	reactions, err := db.reactionsOf("message_reactions", ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if r, ok := reactions[messages[i].ID]; ok {
+			messages[i].Reactions = r
+		}
+	}
+	return nil
+}
+
+// reactionsOf reads the reactions of messages from a reactions table, by message ID
+func (db *DB) reactionsOf(table string, ids []int) (map[int][]MessageReaction, error) {
+	reactions := make(map[int][]MessageReaction)
+	if len(ids) == 0 {
+		return reactions, nil
+	}
+	in, args := inClause(ids)
+	rows, err := db.Db.Query(`
+		SELECT message_id, emoji, user_id FROM `+table+`
+		WHERE message_id IN `+in+`
+		ORDER BY message_id, created_at, user_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, userID int
+		var emoji string
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			return nil, err
+		}
+		list := reactions[messageID]
+		found := false
+		for i := range list {
+			if list[i].Emoji == emoji {
+				list[i].UserIDs = append(list[i].UserIDs, userID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, MessageReaction{Emoji: emoji, UserIDs: []int{userID}})
+		}
+		reactions[messageID] = list
+	}
+	return reactions, rows.Err()
+}
+
+// EditChatMessage replaces the content of a message, keeping the previous one in its history
+func (db *DB) EditChatMessage(messageID int, content string) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`INSERT INTO message_edits (message_id, content, edited_at)
+		SELECT id, content, ? FROM messages WHERE id = ?`, now, messageID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE messages SET content = ?, edited_at = ? WHERE id = ?", content, now, messageID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetChatMessageEdits returns the previous versions of a message, oldest first
+func (db *DB) GetChatMessageEdits(messageID int) ([]MessageEdit, error) {
+	rows, err := db.Db.Query("SELECT content, edited_at FROM message_edits WHERE message_id = ? ORDER BY id", messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []MessageEdit{}
+	for rows.Next() {
+		var e MessageEdit
+		if err := rows.Scan(&e.Content, &e.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+// DeleteChatMessage turns a message into a tombstone for everyone, its history and reactions go with it
+func (db *DB) DeleteChatMessage(messageID int) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"DELETE FROM message_edits WHERE message_id = ?",
+		"DELETE FROM message_reactions WHERE message_id = ?",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, messageID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE messages SET content = '', deleted_at = ? WHERE id = ?", time.Now().UTC(), messageID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HideChatMessage deletes a message for one member only
+func (db *DB) HideChatMessage(messageID, userID int) error {
+	_, err := db.Db.Exec("INSERT OR IGNORE INTO message_hidden (message_id, user_id, hidden_at) VALUES (?, ?, ?)",
+		messageID, userID, time.Now().UTC())
+	return err
+}
+
+// GetMessageAudience lists the members of a chat who didn't delete a message for themselves
+func (db *DB) GetMessageAudience(chatID, messageID int) ([]int, error) {
+	rows, err := db.Db.Query(`SELECT user_id FROM chat_members
+		WHERE chat_id = ? AND user_id NOT IN (SELECT user_id FROM message_hidden WHERE message_id = ?)
+		ORDER BY user_id`, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AddMessageReaction reacts to a message, false when the user already reacted with that emoji
+func (db *DB) AddMessageReaction(messageID, userID int, emoji string) (bool, error) {
+	res, err := db.Db.Exec("INSERT OR IGNORE INTO message_reactions (message_id, user_id, emoji, created_at) VALUES (?, ?, ?, ?)",
+		messageID, userID, emoji, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveMessageReaction takes a reaction back, false when there was none
+func (db *DB) RemoveMessageReaction(messageID, userID int, emoji string) (bool, error) {
+	res, err := db.Db.Exec("DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?",
+		messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"time"
+)
+
+// IsEmailVerified reports whether a user confirmed their email address
+func (db *DB) IsEmailVerified(userID int) (bool, error) {
+	var verified bool
+	err := db.Db.QueryRow("SELECT email_verified_at IS NOT NULL FROM users WHERE id = ?", userID).Scan(&verified)
+	return verified, err
+}
+
+// MarkEmailVerified verifies a user, as long as the address the link was sent to is still theirs
+func (db *DB) MarkEmailVerified(userID int, email string) (bool, error) {
+	_, err := db.Db.Exec("UPDATE users SET email_verified_at = ? WHERE id = ? AND email = ? AND email_verified_at IS NULL",
+		time.Now().UTC(), userID, email)
+	if err != nil {
+		return false, err
+	}
+	var verified bool
+	err = db.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND email = ? AND email_verified_at IS NOT NULL)", userID, email).Scan(&verified)
+	return verified, err
+}
+
+// ClaimVerificationEmail records that a verification email is being sent, it returns false
+// when the user is already verified or the previous email is more recent than interval
+func (db *DB) ClaimVerificationEmail(userID int, interval time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	res, err := db.Db.Exec(`UPDATE users SET verification_sent_at = ?
+		WHERE id = ? AND email_verified_at IS NULL AND (verification_sent_at IS NULL OR verification_sent_at < ?)`,
+		now, userID, now.Add(-interval))
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+// GetVerificationSentAt returns when the last verification email of a user was sent
+func (db *DB) GetVerificationSentAt(userID int) (*time.Time, error) {
+	var sentAt *time.Time
+	err := db.Db.QueryRow("SELECT verification_sent_at FROM users WHERE id = ?", userID).Scan(&sentAt)
+	return sentAt, err
+}
+
+// DeleteUnverifiedUsers removes accounts created before a date that were never verified,
+// along with what they left behind, and returns how many were deleted
+func (db *DB) DeleteUnverifiedUsers(createdBefore time.Time) (int, error) {
+	rows, err := db.Db.Query("SELECT id FROM users WHERE email_verified_at IS NULL AND created_at < ?",
+		createdBefore.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+
+	for _, id := range userIDs {
+		if err := db.deleteUser(id); err != nil {
+			return 0, err
+		}
+	}
+	return len(userIDs), nil
+}
+
+// foreign keys aren't enforced, so every row pointing at the user is removed by hand
+func (db *DB) deleteUser(userID int) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM token WHERE user_id = ?",
+		"DELETE FROM password_resets WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM login_challenges WHERE user_id = ?",
+		"DELETE FROM account_lockouts WHERE user_id = ?",
+		"DELETE FROM login_throttles WHERE key = 'user:' || ?",
+		"DELETE FROM api_tokens WHERE user_id = ?",
+		"DELETE FROM ws_tickets WHERE user_id = ?",
+		"DELETE FROM message_edits WHERE message_id IN (SELECT id FROM messages WHERE sender_id = ?)",
+		"DELETE FROM message_reactions WHERE user_id = ?1 OR message_id IN (SELECT id FROM messages WHERE sender_id = ?1)",
+		"DELETE FROM message_hidden WHERE user_id = ?1 OR message_id IN (SELECT id FROM messages WHERE sender_id = ?1)",
+		"DELETE FROM messages WHERE sender_id = ?",
+		"DELETE FROM chat_members WHERE user_id = ?",
+		"DELETE FROM group_message_edits WHERE message_id IN (SELECT id FROM group_messages WHERE sender_id = ?)",
+		"DELETE FROM group_message_reactions WHERE user_id = ?1 OR message_id IN (SELECT id FROM group_messages WHERE sender_id = ?1)",
+		"DELETE FROM group_message_hidden WHERE user_id = ?1 OR message_id IN (SELECT id FROM group_messages WHERE sender_id = ?1)",
+		"DELETE FROM group_messages WHERE sender_id = ?",
+		"DELETE FROM follow_requests WHERE follower_id = ?1 OR following_id = ?1",
+		"DELETE FROM user_blocks WHERE blocker_id = ?1 OR blocked_id = ?1",
+		"DELETE FROM group_members WHERE user_id = ?",
+		"DELETE FROM event_responses WHERE user_id = ?",
+		"DELETE FROM post_interactions WHERE user_id = ?",
+		"DELETE FROM post_privacy_users WHERE user_id = ?",
+		"DELETE FROM notifications WHERE recever_id = ?1 OR sender_id = ?1",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -330,9 +330,9 @@ func (db *DB) GetUserIDFromMember(memberID int) (int, error) {
 	return userID, err
 }
 
-// IsUserGroupMember checks if a user is a member of a group
+// IsUserGroupMember checks if a user is a member of a group, pending requests and invitations aside
 func (db *DB) IsUserGroupMember(userID, groupID int) (bool, error) {
 	var exists bool
-	err := db.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM group_members WHERE user_id = ? AND group_id = ?)", userID, groupID).Scan(&exists)
+	err := db.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM group_members WHERE user_id = ? AND group_id = ? AND status IN ('approved', 'creator'))", userID, groupID).Scan(&exists)
 	return exists, err
 }
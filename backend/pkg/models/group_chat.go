@@ -5,34 +5,141 @@ import (
 )
 
 type GroupMessage struct {
-	ID        int       `json:"id"`
-	GroupID   int       `json:"group_id"`
-	SenderID  int       `json:"sender_id"`
-	Sender    string    `json:"sender"`
-	Text      string    `json:"text"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        int        `json:"id"`
+	GroupID   int        `json:"group_id"`
+	SenderID  int        `json:"sender_id"`
+	Sender    string     `json:"sender"`
+	Text      string     `json:"text"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+	// a message deleted for everyone is kept as a tombstone, without text or reactions
+	Deleted     bool              `json:"deleted"`
+	Reactions   []MessageReaction `json:"reactions"`
+	Attachments []Attachment      `json:"attachments"`
 }
 
-func (db *DB) InsertGroupMessage(msg GroupMessage) error {
-	_, err := db.Db.Exec(`INSERT INTO group_messages (group_id, sender_id, sender, text, created_at) VALUES (?, ?, ?, ?, ?)`,
+// InsertGroupMessage stores a group message with the sender's uploads in attachmentIDs,
+// and fills its ID and attachments
+func (db *DB) InsertGroupMessage(msg *GroupMessage, attachmentIDs []int) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO group_messages (group_id, sender_id, sender, text, created_at) VALUES (?, ?, ?, ?, ?)`,
 		msg.GroupID, msg.SenderID, msg.Sender, msg.Text, msg.CreatedAt)
-	return err
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := attachToMessage(tx, "group_message_id", int(id), msg.SenderID, attachmentIDs); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	msg.ID = int(id)
+	msg.Reactions = []MessageReaction{}
+	messages := []GroupMessage{*msg}
+	if err := db.loadGroupAttachments(messages); err != nil {
+		return err
+	}
+	*msg = messages[0]
+	return nil
 }
 
-func (db *DB) GetGroupMessages(groupID int, limit int) ([]GroupMessage, error) {
-	rows, err := db.Db.Query(`SELECT id, group_id, sender_id, sender, text, created_at FROM group_messages WHERE group_id = ? ORDER BY created_at DESC LIMIT ?`, groupID, limit)
+// GroupMessagePage is a window of a group chat, oldest first
+type GroupMessagePage struct {
+	Messages []GroupMessage `json:"messages"`
+	PageInfo
+}
+
+// GetGroupMessages returns a window of a group chat, without the messages the viewer deleted for themselves
+func (db *DB) GetGroupMessages(groupID, viewerID int, q PageQuery) (*GroupMessagePage, error) {
+	ids, info, err := db.pageIDs("group_messages",
+		"group_id = ? AND id NOT IN (SELECT message_id FROM group_message_hidden WHERE user_id = ?)",
+		[]interface{}{groupID, viewerID}, q)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &GroupMessagePage{Messages: []GroupMessage{}, PageInfo: info}
+	if len(ids) == 0 {
+		return page, nil
+	}
+	in, args := inClause(ids)
+	rows, err := db.Db.Query("SELECT "+groupMessageColumns+" FROM group_messages WHERE id IN "+in+" ORDER BY id", args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []GroupMessage
 	for rows.Next() {
-		var msg GroupMessage
-		if err := rows.Scan(&msg.ID, &msg.GroupID, &msg.SenderID, &msg.Sender, &msg.Text, &msg.CreatedAt); err != nil {
+		msg, err := scanGroupMessage(rows)
+		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, msg)
+		page.Messages = append(page.Messages, *msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return page, db.loadGroupMessageDetails(page.Messages)
+}
+
+// GetGroupMessageRetention returns how many days the messages of a group are kept, nil for forever
+func (db *DB) GetGroupMessageRetention(groupID int) (*int, error) {
+	var days *int
+	err := db.Db.QueryRow("SELECT message_retention_days FROM groups WHERE id = ?", groupID).Scan(&days)
+	return days, err
+}
+
+func (db *DB) SetGroupMessageRetention(groupID int, days *int) error {
+	_, err := db.Db.Exec("UPDATE groups SET message_retention_days = ? WHERE id = ?", days, groupID)
+	return err
+}
+
+// DeleteExpiredGroupMessages applies the retention of every group that has one,
+// deleting the messages sent before now minus its number of days
+func (db *DB) DeleteExpiredGroupMessages(now time.Time) (int64, error) {
+	rows, err := db.Db.Query("SELECT id, message_retention_days FROM groups WHERE message_retention_days IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+	retention := make(map[int]int)
+	for rows.Next() {
+		var groupID, days int
+		if err := rows.Scan(&groupID, &days); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		retention[groupID] = days
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for groupID, days := range retention {
+		res, err := db.Db.Exec("DELETE FROM group_messages WHERE group_id = ? AND created_at < ?",
+			groupID, now.UTC().AddDate(0, 0, -days))
+		if err != nil {
+			return deleted, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	if deleted > 0 {
+		return deleted, db.deleteGroupMessageLeftovers()
 	}
-	return messages, nil
+	return deleted, nil
 }
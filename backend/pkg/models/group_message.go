@@ -0,0 +1,175 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const groupMessageColumns = "id, group_id, sender_id, sender, text, created_at, edited_at, deleted_at IS NOT NULL"
+
+func scanGroupMessage(row interface{ Scan(...interface{}) error }) (*GroupMessage, error) {
+	m := &GroupMessage{Reactions: []MessageReaction{}, Attachments: []Attachment{}}
+	err := row.Scan(&m.ID, &m.GroupID, &m.SenderID, &m.Sender, &m.Text, &m.CreatedAt, &m.EditedAt, &m.Deleted)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetGroupMessage returns one message of a group with its reactions and attachments
+func (db *DB) GetGroupMessage(groupID, messageID int) (*GroupMessage, error) {
+	m, err := scanGroupMessage(db.Db.QueryRow("SELECT "+groupMessageColumns+" FROM group_messages WHERE id = ? AND group_id = ?", messageID, groupID))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("message not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	messages := []GroupMessage{*m}
+	if err := db.loadGroupMessageDetails(messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+// loadGroupMessageDetails fills the reactions and attachments of group messages
+func (db *DB) loadGroupMessageDetails(messages []GroupMessage) error {
+	ids := make([]int, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	reactions, err := db.reactionsOf("group_message_reactions", ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if r, ok := reactions[messages[i].ID]; ok {
+			messages[i].Reactions = r
+		}
+	}
+	return db.loadGroupAttachments(messages)
+}
+
+// EditGroupMessage replaces the text of a group message, keeping the previous one in its history
+func (db *DB) EditGroupMessage(messageID int, text string) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`INSERT INTO group_message_edits (message_id, text, edited_at)
+		SELECT id, text, ? FROM group_messages WHERE id = ?`, now, messageID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE group_messages SET text = ?, edited_at = ? WHERE id = ?", text, now, messageID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetGroupMessageEdits returns the previous versions of a group message, oldest first
+func (db *DB) GetGroupMessageEdits(messageID int) ([]MessageEdit, error) {
+	rows, err := db.Db.Query("SELECT text, edited_at FROM group_message_edits WHERE message_id = ? ORDER BY id", messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []MessageEdit{}
+	for rows.Next() {
+		var e MessageEdit
+		if err := rows.Scan(&e.Content, &e.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+// DeleteGroupMessage turns a group message into a tombstone for everyone, its history and reactions go with it
+func (db *DB) DeleteGroupMessage(messageID int) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"DELETE FROM group_message_edits WHERE message_id = ?",
+		"DELETE FROM group_message_reactions WHERE message_id = ?",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, messageID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE group_messages SET text = '', deleted_at = ? WHERE id = ?", time.Now().UTC(), messageID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HideGroupMessage deletes a group message for one member only
+func (db *DB) HideGroupMessage(messageID, userID int) error {
+	_, err := db.Db.Exec("INSERT OR IGNORE INTO group_message_hidden (message_id, user_id, hidden_at) VALUES (?, ?, ?)",
+		messageID, userID, time.Now().UTC())
+	return err
+}
+
+// GetGroupMessageHiders lists the users who deleted a group message for themselves
+func (db *DB) GetGroupMessageHiders(messageID int) (map[int]bool, error) {
+	rows, err := db.Db.Query("SELECT user_id FROM group_message_hidden WHERE message_id = ?", messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hiders := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		hiders[id] = true
+	}
+	return hiders, rows.Err()
+}
+
+// AddGroupMessageReaction reacts to a group message, false when the user already reacted with that emoji
+func (db *DB) AddGroupMessageReaction(messageID, userID int, emoji string) (bool, error) {
+	res, err := db.Db.Exec("INSERT OR IGNORE INTO group_message_reactions (message_id, user_id, emoji, created_at) VALUES (?, ?, ?, ?)",
+		messageID, userID, emoji, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveGroupMessageReaction takes a reaction to a group message back, false when there was none
+func (db *DB) RemoveGroupMessageReaction(messageID, userID int, emoji string) (bool, error) {
+	res, err := db.Db.Exec("DELETE FROM group_message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?",
+		messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// deleteGroupMessageLeftovers removes the edits, reactions and hidden marks of group messages
+// that no longer exist, foreign keys aren't enforced
+func (db *DB) deleteGroupMessageLeftovers() error {
+	for _, table := range []string{"group_message_edits", "group_message_reactions", "group_message_hidden"} {
+		if _, err := db.Db.Exec("DELETE FROM " + table + " WHERE message_id NOT IN (SELECT id FROM group_messages)"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
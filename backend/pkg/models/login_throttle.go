@@ -0,0 +1,126 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type AccountLockout struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	IPAddress   string     `json:"ip_address"`
+	Failures    int        `json:"failures"`
+	LockedUntil time.Time  `json:"locked_until"`
+	UnlockedAt  *time.Time `json:"unlocked_at"`
+	UnlockedBy  *int       `json:"unlocked_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// GetLockedUntil returns until when a throttle key is locked, nil when it isn't
+func (db *DB) GetLockedUntil(key string) (*time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := db.Db.QueryRow("SELECT locked_until FROM login_throttles WHERE key = ?", key).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !lockedUntil.Valid || lockedUntil.Time.Before(time.Now()) {
+		return nil, nil
+	}
+	return &lockedUntil.Time, nil
+}
+
+// CountLoginFailure adds a failure to a throttle key and returns the new count.
+// Counting starts over when the last failure is older than window and no lock is running.
+func (db *DB) CountLoginFailure(key string, window time.Duration) (int, error) {
+	now := time.Now().UTC()
+	var failures int
+	err := db.Db.QueryRow(`
+		INSERT INTO login_throttles (key, failures, last_failure_at) VALUES (?1, 1, ?2)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE
+				WHEN last_failure_at < ?3 AND (locked_until IS NULL OR locked_until < ?2) THEN 1
+				ELSE failures + 1
+			END,
+			last_failure_at = ?2
+		RETURNING failures`, key, now, now.Add(-window)).Scan(&failures)
+	return failures, err
+}
+
+// LockLogin blocks a throttle key until a date
+func (db *DB) LockLogin(key string, until time.Time) error {
+	_, err := db.Db.Exec("UPDATE login_throttles SET locked_until = ? WHERE key = ?", until.UTC(), key)
+	return err
+}
+
+// ClearLoginFailures forgets the failures and lock of a throttle key
+func (db *DB) ClearLoginFailures(key string) error {
+	_, err := db.Db.Exec("DELETE FROM login_throttles WHERE key = ?", key)
+	return err
+}
+
+// DeleteStaleLoginThrottles removes counters with no recent failure and no running lock
+func (db *DB) DeleteStaleLoginThrottles(window time.Duration) error {
+	now := time.Now().UTC()
+	_, err := db.Db.Exec("DELETE FROM login_throttles WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)",
+		now.Add(-window), now)
+	return err
+}
+
+// InsertAccountLockout records that an account was locked, so its owner can see it
+func (db *DB) InsertAccountLockout(userID int, ipAddress string, failures int, lockedUntil time.Time) (int, error) {
+	var id int
+	err := db.Db.QueryRow("INSERT INTO account_lockouts (user_id, ip_address, failures, locked_until, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		userID, ipAddress, failures, lockedUntil.UTC(), time.Now().UTC()).Scan(&id)
+	return id, err
+}
+
+// GetAccountLockouts lists the most recent lockouts of an account
+func (db *DB) GetAccountLockouts(userID int) ([]AccountLockout, error) {
+	rows, err := db.Db.Query(`SELECT id, user_id, ip_address, failures, locked_until, unlocked_at, unlocked_by, created_at
+		FROM account_lockouts WHERE user_id = ? ORDER BY id DESC LIMIT 50`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lockouts []AccountLockout
+	for rows.Next() {
+		var l AccountLockout
+		var unlockedAt sql.NullTime
+		var unlockedBy sql.NullInt64
+		err := rows.Scan(&l.ID, &l.UserID, &l.IPAddress, &l.Failures, &l.LockedUntil, &unlockedAt, &unlockedBy, &l.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if unlockedAt.Valid {
+			l.UnlockedAt = &unlockedAt.Time
+		}
+		if unlockedBy.Valid {
+			by := int(unlockedBy.Int64)
+			l.UnlockedBy = &by
+		}
+		lockouts = append(lockouts, l)
+	}
+	return lockouts, nil
+}
+
+// MarkAccountUnlocked closes the running lockouts of an account, on behalf of an admin
+func (db *DB) MarkAccountUnlocked(userID, adminID int) error {
+	now := time.Now().UTC()
+	_, err := db.Db.Exec("UPDATE account_lockouts SET unlocked_at = ?, unlocked_by = ? WHERE user_id = ? AND unlocked_at IS NULL AND locked_until > ?",
+		now, adminID, userID, now)
+	return err
+}
+
+// IsAdmin reports whether a user may use the admin endpoints
+func (db *DB) IsAdmin(userID int) (bool, error) {
+	var isAdmin bool
+	err := db.Db.QueryRow("SELECT is_admin FROM users WHERE id = ?", userID).Scan(&isAdmin)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isAdmin, err
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// PageQuery picks a window of a conversation by message ID. Before and After read older
+// or newer messages than a cursor, Around centers the window on a message, and without
+// any of them the latest messages are returned.
+type PageQuery struct {
+	Before int
+	After  int
+	Around int
+	Limit  int
+}
+
+// PageInfo tells whether a conversation goes on past a window. HasMore looks in the
+// direction being read: older messages for the latest ones or Before, newer ones for After,
+// either side for Around.
+type PageInfo struct {
+	HasMore       bool `json:"has_more"`
+	HasMoreBefore bool `json:"has_more_before"`
+	HasMoreAfter  bool `json:"has_more_after"`
+}
+
+func newPageInfo(q PageQuery, hasBefore, hasAfter bool) PageInfo {
+	info := PageInfo{HasMoreBefore: hasBefore, HasMoreAfter: hasAfter}
+	switch {
+	case q.Around > 0:
+		info.HasMore = hasBefore || hasAfter
+	case q.After > 0:
+		info.HasMore = hasAfter
+	default:
+		info.HasMore = hasBefore
+	}
+	return info
+}
+
+// pageIDs returns the IDs of a window of messages, oldest first, and whether the
+// conversation goes on around it. scope restricts the rows of table to one conversation.
+func (db *DB) pageIDs(table, scope string, args []interface{}, q PageQuery) ([]int, PageInfo, error) {
+	query := func(cond, order string, limit int, extra ...interface{}) ([]int, error) {
+		rows, err := db.Db.Query("SELECT id FROM "+table+" WHERE "+scope+" AND "+cond+" ORDER BY id "+order+" LIMIT ?",
+			append(append(append([]interface{}{}, args...), extra...), limit)...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var ids []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, rows.Err()
+	}
+	exists := func(cond string, extra ...interface{}) (bool, error) {
+		var found bool
+		err := db.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM "+table+" WHERE "+scope+" AND "+cond+")",
+			append(append([]interface{}{}, args...), extra...)...).Scan(&found)
+		return found, err
+	}
+	reverse := func(ids []int) []int {
+		for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+		return ids
+	}
+
+	switch {
+	case q.Around > 0:
+		found, err := exists("id = ?", q.Around)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		if !found {
+			return nil, PageInfo{}, errors.New("message not found")
+		}
+		before, err := query("id < ?", "DESC", q.Limit, q.Around)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		after, err := query("id > ?", "ASC", q.Limit, q.Around)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		// the message itself, then as many older messages as newer ones, a side
+		// running out leaves its room to the other
+		older := min(len(before), (q.Limit-1)/2)
+		newer := min(len(after), q.Limit-1-older)
+		older = min(len(before), q.Limit-1-newer)
+		hasBefore, hasAfter := len(before) > older, len(after) > newer
+		ids := append(reverse(before[:older]), q.Around)
+		return append(ids, after[:newer]...), newPageInfo(q, hasBefore, hasAfter), nil
+
+	case q.After > 0:
+		ids, err := query("id > ?", "ASC", q.Limit+1, q.After)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		hasAfter := len(ids) > q.Limit
+		if hasAfter {
+			ids = ids[:q.Limit]
+		}
+		hasBefore, err := exists("id <= ?", q.After)
+		return ids, newPageInfo(q, hasBefore, hasAfter), err
+
+	default:
+		cond, extra := "1 = 1", []interface{}{}
+		if q.Before > 0 {
+			cond, extra = "id < ?", []interface{}{q.Before}
+		}
+		ids, err := query(cond, "DESC", q.Limit+1, extra...)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		hasBefore := len(ids) > q.Limit
+		if hasBefore {
+			ids = ids[:q.Limit]
+		}
+		hasAfter := false
+		if q.Before > 0 {
+			hasAfter, err = exists("id >= ?", q.Before)
+		}
+		return reverse(ids), newPageInfo(q, hasBefore, hasAfter), err
+	}
+}
+
+// inClause returns "(?, ?, ...)" with the IDs as arguments
+func inClause(ids []int) (string, []interface{}) {
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return "(?" + strings.Repeat(", ?", len(ids)-1) + ")", args
+}
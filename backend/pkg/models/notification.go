@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
@@ -15,8 +16,24 @@ type Notification struct {
 	GroupName  *string `json:"group_name"`
 	Message    string  `json:"message"`
 	CreatedAt  string  `json:"created_at"`
+	IsRead     bool    `json:"is_read"`
+	Archived   bool    `json:"archived"`
 }
 
+// NotificationQuery picks a page of a user's notifications, the latest first. Before
+// reads older notifications than a cursor, Read filters on the read state when set,
+// and archived notifications are only listed with Archived.
+type NotificationQuery struct {
+	Before   int
+	Limit    int
+	Type     string
+	Read     *bool
+	Archived bool
+}
+
+// OnNotification is called with every notification inserted, the gateway pushes them to their receiver from it
+var OnNotification func(Notification)
+
 // InsertNotification inserts a new notification into the database
 func (db *DB) InsertNotification(notif *Notification) (Notification, error) {
 	query := `INSERT INTO notifications (type, related_id, recever_id, sender_id, is_read, created_at) VALUES (?, ?, ?, ?, 0, CURRENT_TIMESTAMP)`
@@ -29,6 +46,9 @@ func (db *DB) InsertNotification(notif *Notification) (Notification, error) {
 		return Notification{}, err
 	}
 	notifToSend, err := db.getNotification(notifId)
+	if err == nil && OnNotification != nil {
+		OnNotification(notifToSend)
+	}
 	return notifToSend, err
 }
 
@@ -43,8 +63,8 @@ func (db *DB) DeleteGroupMemberNotifications(groupID, userID int) error {
 	return err
 }
 
-func (db *DB) getNotification(notifId int64) (Notification, error) {
-	query := `
+// notificationColumns reads a notification with its sender and group, from notifications aliased n
+const notificationColumns = `
 		SELECT 
 		    n.id, 
 		    n.type, 
@@ -54,47 +74,34 @@ func (db *DB) getNotification(notifId int64) (Notification, error) {
 		    COALESCE(ge.group_id, gm.group_id) AS group_id,
 		    u.first_name || ' ' || u.last_name AS sender_name,
 		    g.title AS group_name,
-		    n.created_at
+		    n.created_at,
+		    COALESCE(n.is_read, 0),
+		    n.archived_at IS NOT NULL
 		FROM notifications AS n
 		JOIN users As u ON n.sender_id = u.id
 		LEFT JOIN group_events AS ge ON (n.related_id = ge.id AND n.type = 'group event')
 		LEFT JOIN group_members AS gm ON (n.related_id = gm.id AND (n.type = 'group join request' OR n.type = 'group join invitation' OR n.type = 'group invitation accepted' OR n.type = 'group join request approved'))
-		LEFT JOIN groups AS g ON g.id = COALESCE(ge.group_id, gm.group_id)
-		WHERE n.id = ?;
-	`
+		LEFT JOIN groups AS g ON g.id = COALESCE(ge.group_id, gm.group_id)`
+
+func (db *DB) scanNotification(row interface{ Scan(...interface{}) error }) (Notification, error) {
 	var notif Notification
 	var timeCreated time.Time
-	err := db.Db.QueryRow(query, notifId).Scan(&notif.Id, &notif.Type, &notif.RelatedId, &notif.SenderId, &notif.ReceiverId, &notif.GroupId, &notif.SenderName, &notif.GroupName, &timeCreated)
+	err := row.Scan(&notif.Id, &notif.Type, &notif.RelatedId, &notif.SenderId, &notif.ReceiverId, &notif.GroupId, &notif.SenderName, &notif.GroupName, &timeCreated, &notif.IsRead, &notif.Archived)
 	if err != nil {
 		return Notification{}, err
 	}
 	notif.CreatedAt = timeCreated.Format("Jan 2, 2006 at 15:04")
 	notif.Message = db.generateNotificationMessage(notif)
-	return notif, err
+	return notif, nil
 }
 
-// GetNotifications retrieves unread notifications for a user
-func (db *DB) GetNotifications(userId int) ([]Notification, error) {
-	query := `
-		SELECT 
-		    n.id, 
-		    n.type, 
-		    n.related_id,
-		    n.sender_id,
-			n.recever_id,
-		    COALESCE(ge.group_id, gm.group_id) AS group_id,
-		    u.first_name || ' ' || u.last_name AS sender_name,
-		    g.title AS group_name,
-		    n.created_at
-		FROM notifications AS n
-		JOIN users As u ON n.sender_id = u.id
-		LEFT JOIN group_events AS ge ON (n.related_id = ge.id AND n.type = 'group event')
-		LEFT JOIN group_members AS gm ON (n.related_id = gm.id AND (n.type = 'group join request' OR n.type = 'group join invitation' OR n.type = 'group invitation accepted' OR n.type = 'group join request approved'))
-		LEFT JOIN groups AS g ON g.id = COALESCE(ge.group_id, gm.group_id)
-		WHERE n.recever_id = ? order by n.id desc;
+func (db *DB) getNotification(notifId int64) (Notification, error) {
+	return db.scanNotification(db.Db.QueryRow(notificationColumns+" WHERE n.id = ?", notifId))
+}
 
-	`
-	rows, err := db.Db.Query(query, userId)
+// queryNotifications runs a query starting with notificationColumns
+func (db *DB) queryNotifications(query string, args ...interface{}) ([]Notification, error) {
+	rows, err := db.Db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -102,30 +109,116 @@ func (db *DB) GetNotifications(userId int) ([]Notification, error) {
 
 	var notifications []Notification
 	for rows.Next() {
-		var notif Notification
-		var timeCreated time.Time
-		err := rows.Scan(&notif.Id, &notif.Type, &notif.RelatedId, &notif.SenderId, &notif.ReceiverId, &notif.GroupId, &notif.SenderName, &notif.GroupName, &timeCreated)
+		notif, err := db.scanNotification(rows)
 		if err != nil {
 			return nil, err
 		}
-		notif.CreatedAt = timeCreated.Format("Jan 2, 2006 at 15:04")
-		notif.Message = db.generateNotificationMessage(notif)
 		notifications = append(notifications, notif)
 	}
+	return notifications, rows.Err()
+}
+
+// GetNotifications retrieves a page of a user's notifications and whether older ones match too
+func (db *DB) GetNotifications(userId int, q NotificationQuery) ([]Notification, bool, error) {
+	where := []string{"n.recever_id = ?"}
+	args := []interface{}{userId}
+	if q.Before > 0 {
+		where = append(where, "n.id < ?")
+		args = append(args, q.Before)
+	}
+	if q.Type != "" {
+		where = append(where, "n.type = ?")
+		args = append(args, q.Type)
+	}
+	if q.Read != nil {
+		where = append(where, "COALESCE(n.is_read, 0) = ?")
+		args = append(args, *q.Read)
+	}
+	if q.Archived {
+		where = append(where, "n.archived_at IS NOT NULL")
+	} else {
+		where = append(where, "n.archived_at IS NULL")
+	}
+
+	// one more row than asked tells whether there is a next page
+	args = append(args, q.Limit+1)
+	notifications, err := db.queryNotifications(notificationColumns+" WHERE "+strings.Join(where, " AND ")+" ORDER BY n.id DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, false, err
+	}
+	hasMore := len(notifications) > q.Limit
+	if hasMore {
+		notifications = notifications[:q.Limit]
+	}
+	return notifications, hasMore, nil
+}
+
+// GetNotificationsAfter returns the latest notifications of a user newer than afterID,
+// at most limit of them, oldest first. Archived notifications are left out.
+func (db *DB) GetNotificationsAfter(userId, afterID, limit int) ([]Notification, error) {
+	notifications, err := db.queryNotifications(notificationColumns+" WHERE n.recever_id = ? AND n.id > ? AND n.archived_at IS NULL ORDER BY n.id DESC LIMIT ?", userId, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(notifications)-1; i < j; i, j = i+1, j-1 {
+		notifications[i], notifications[j] = notifications[j], notifications[i]
+	}
 	return notifications, nil
 }
 
-// MarkNotificationAsRead updates a notification as read
-func (db *DB) MarkNotificationAsRead(notifID int) error {
-	// fmt.Println("Marking notification as read:", notifID)
-	_, err := db.Db.Exec("UPDATE notifications SET is_read = 1 WHERE recever_id = ?", notifID)
-	return err
+// notificationSelection restricts an update of a user's notifications to ids, or to all of them when ids is nil
+func notificationSelection(userId int, ids []int) (string, []interface{}) {
+	if ids == nil {
+		return "recever_id = ?", []interface{}{userId}
+	}
+	in, args := inClause(ids)
+	return "recever_id = ? AND id IN " + in, append([]interface{}{userId}, args...)
+}
+
+// SetNotificationsRead marks notifications of a user as read or unread, every one of them when
+// ids is nil, and returns how many changed. Other users' notifications are left alone.
+func (db *DB) SetNotificationsRead(userId int, ids []int, read bool) (int64, error) {
+	where, args := notificationSelection(userId, ids)
+	res, err := db.Db.Exec("UPDATE notifications SET is_read = ? WHERE "+where+" AND COALESCE(is_read, 0) != ?",
+		append(append([]interface{}{read}, args...), read)...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SetNotificationsArchived moves notifications of a user out of their inbox or back, every
+// one of them when ids is nil, and returns how many changed
+func (db *DB) SetNotificationsArchived(userId int, ids []int, archived bool) (int64, error) {
+	where, args := notificationSelection(userId, ids)
+	query := "UPDATE notifications SET archived_at = ? WHERE " + where + " AND archived_at IS NULL"
+	value := interface{}(time.Now().UTC())
+	if !archived {
+		query = "UPDATE notifications SET archived_at = ? WHERE " + where + " AND archived_at IS NOT NULL"
+		value = nil
+	}
+	res, err := db.Db.Exec(query, append([]interface{}{value}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RemoveNotifications deletes notifications of a user, every one of them when ids is nil,
+// and returns how many were deleted
+func (db *DB) RemoveNotifications(userId int, ids []int) (int64, error) {
+	where, args := notificationSelection(userId, ids)
+	res, err := db.Db.Exec("DELETE FROM notifications WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
-// GetUnreadCount returns the number of unread notifications for a user
+// GetUnreadCount returns the number of unread notifications for a user, archived ones aside
 func (db *DB) GetUnreadCount(userId int) (int, error) {
 	var notifsNum int
-	err := db.Db.QueryRow("SELECT COUNT(*) FROM notifications WHERE recever_id = ? AND is_read = 0;", userId).Scan(&notifsNum)
+	err := db.Db.QueryRow("SELECT COUNT(*) FROM notifications WHERE recever_id = ? AND is_read = 0 AND archived_at IS NULL;", userId).Scan(&notifsNum)
 	return notifsNum, err
 }
 
@@ -144,6 +237,8 @@ func (db *DB) generateNotificationMessage(notif Notification) string {
 		return notif.SenderName + " sent you a follow request"
 	case "group event":
 		return notif.SenderName + " created a new event in " + *notif.GroupName
+	case "account locked":
+		return "Your account was locked after too many failed login attempts"
 	default:
 		return "You have a new notification"
 	}
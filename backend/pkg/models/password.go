@@ -0,0 +1,184 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher is a password hashing algorithm
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// Owns reports whether a hash was produced by this algorithm
+	Owns(hash string) bool
+	// NeedsRehash reports whether a hash of this algorithm uses outdated parameters
+	NeedsRehash(hash string) bool
+}
+
+// the algorithm used for new passwords, older hashes are upgraded on login
+var Hasher PasswordHasher = BcryptHasher{Cost: bcrypt.DefaultCost}
+
+// every algorithm a stored hash may use
+func knownHashers() []PasswordHasher {
+	return []PasswordHasher{Hasher, BcryptHasher{}, Argon2idHasher{}}
+}
+
+// VerifyPassword checks a password against a stored hash of any known algorithm,
+// and reports whether the hash should be replaced by one from the current Hasher
+func VerifyPassword(hash, password string) (bool, bool, error) {
+	for _, h := range knownHashers() {
+		if !h.Owns(hash) {
+			continue
+		}
+		ok, err := h.Verify(hash, password)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, !Hasher.Owns(hash) || Hasher.NeedsRehash(hash), nil
+	}
+	return false, false, errors.New("unknown password hash format")
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// DummyPasswordCheck spends the same time as a real verification,
+// so a login for an unknown account can't be told apart by its timing
+func DummyPasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = Hasher.Hash("dummy password used for timing")
+	})
+	VerifyPassword(dummyHash, password)
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (b BcryptHasher) Hash(password string) (string, error) {
+	cost := b.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	return string(hash), err
+}
+
+func (b BcryptHasher) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b BcryptHasher) Owns(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (b BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	want := b.Cost
+	if want == 0 {
+		want = bcrypt.DefaultCost
+	}
+	return cost != want
+}
+
+// Argon2idHasher encodes hashes as $argon2id$v=19$m=65536,t=3,p=2$salt$key
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+}
+
+// recommended parameters from RFC 9106
+var DefaultArgon2id = Argon2idHasher{Time: 3, Memory: 64 * 1024, Threads: 2, KeyLen: 32}
+
+func (a Argon2idHasher) params() Argon2idHasher {
+	if a.Time == 0 {
+		return DefaultArgon2id
+	}
+	return a
+}
+
+func (a Argon2idHasher) Hash(password string) (string, error) {
+	p := a.params()
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2idHasher) decode(hash string) (Argon2idHasher, []byte, []byte, error) {
+	var p Argon2idHasher
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errors.New("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	p.KeyLen = uint32(len(key))
+	return p, salt, key, nil
+}
+
+func (a Argon2idHasher) Verify(hash, password string) (bool, error) {
+	p, salt, key, err := a.decode(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a Argon2idHasher) Owns(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (a Argon2idHasher) NeedsRehash(hash string) bool {
+	p, _, _, err := a.decode(hash)
+	if err != nil {
+		return true
+	}
+	return p != a.params()
+}
+
+// UpdatePasswordHash hashes a password with the current Hasher and stores it
+func (db *DB) UpdatePasswordHash(userID int, password string) error {
+	hash, err := Hasher.Hash(password)
+	if err != nil {
+		return errors.New("failed to hash password")
+	}
+	_, err = db.Db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hash, userID)
+	return err
+}
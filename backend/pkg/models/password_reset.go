@@ -0,0 +1,59 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type PasswordReset struct {
+	ID        int
+	UserID    int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// InsertPasswordReset stores the hash of a reset token, older unused tokens of the user are dropped
+func (db *DB) InsertPasswordReset(userID int, tokenHash string, expiresAt time.Time) error {
+	_, err := db.Db.Exec("DELETE FROM password_resets WHERE user_id = ? AND used_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Db.Exec("INSERT INTO password_resets (user_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)",
+		userID, tokenHash, expiresAt.UTC(), time.Now().UTC())
+	return err
+}
+
+// GetPasswordReset retrieves a reset token by its hash
+func (db *DB) GetPasswordReset(tokenHash string) (*PasswordReset, error) {
+	var p PasswordReset
+	var usedAt sql.NullTime
+	err := db.Db.QueryRow("SELECT id, user_id, expires_at, used_at FROM password_resets WHERE token_hash = ?", tokenHash).
+		Scan(&p.ID, &p.UserID, &p.ExpiresAt, &usedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("password reset not found")
+		}
+		return nil, err
+	}
+	if usedAt.Valid {
+		p.UsedAt = &usedAt.Time
+	}
+	return &p, nil
+}
+
+// MarkPasswordResetUsed consumes a reset token, it returns false when it was already used
+func (db *DB) MarkPasswordResetUsed(id int) (bool, error) {
+	res, err := db.Db.Exec("UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+// DeleteExpiredPasswordResets removes reset tokens that are past their expiry
+func (db *DB) DeleteExpiredPasswordResets() error {
+	_, err := db.Db.Exec("DELETE FROM password_resets WHERE expires_at < ?", time.Now().UTC())
+	return err
+}
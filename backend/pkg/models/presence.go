@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+)
+
+// FollowedPresence is the presence data of a user followed by someone
+type FollowedPresence struct {
+	UserID     int
+	LastSeenAt *time.Time
+}
+
+// IsPresenceHidden reports whether a user hides their presence from everyone
+func (db *DB) IsPresenceHidden(userID int) (bool, error) {
+	var hidden bool
+	err := db.Db.QueryRow("SELECT hide_presence FROM users WHERE id = ?", userID).Scan(&hidden)
+	return hidden, err
+}
+
+func (db *DB) SetPresenceHidden(userID int, hidden bool) error {
+	_, err := db.Db.Exec("UPDATE users SET hide_presence = ? WHERE id = ?", hidden, userID)
+	return err
+}
+
+// UpdateLastSeen records when a user was last connected
+func (db *DB) UpdateLastSeen(userID int, at time.Time) error {
+	_, err := db.Db.Exec("UPDATE users SET last_seen_at = ? WHERE id = ?", at, userID)
+	return err
+}
+
+// GetFollowerIDs lists the users following someone with an approved request
+func (db *DB) GetFollowerIDs(userID int) ([]int, error) {
+	rows, err := db.Db.Query("SELECT follower_id FROM follow_requests WHERE following_id = ? AND status = 'approved'", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetFollowedPresence lists the users someone follows who share their presence
+func (db *DB) GetFollowedPresence(followerID int) ([]FollowedPresence, error) {
+	rows, err := db.Db.Query(`
+		SELECT u.id, u.last_seen_at FROM users AS u
+		JOIN follow_requests AS f ON f.following_id = u.id
+		WHERE f.follower_id = ? AND f.status = 'approved' AND u.hide_presence = 0
+		ORDER BY u.id`, followerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	presence := []FollowedPresence{}
+	for rows.Next() {
+		var p FollowedPresence
+		if err := rows.Scan(&p.UserID, &p.LastSeenAt); err != nil {
+			return nil, err
+		}
+		presence = append(presence, p)
+	}
+	return presence, rows.Err()
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	SessionID string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// InsertRefreshToken stores the hash of a refresh token belonging to a session
+func (db *DB) InsertRefreshToken(userID int, sessionID, tokenHash string, expiresAt time.Time) error {
+	_, err := db.Db.Exec("INSERT INTO refresh_tokens (user_id, session_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, sessionID, tokenHash, expiresAt.UTC(), time.Now().UTC())
+	return err
+}
+
+// GetRefreshToken retrieves a refresh token by its hash
+func (db *DB) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	var t RefreshToken
+	var usedAt sql.NullTime
+	err := db.Db.QueryRow("SELECT id, user_id, session_id, expires_at, used_at FROM refresh_tokens WHERE token_hash = ?", tokenHash).
+		Scan(&t.ID, &t.UserID, &t.SessionID, &t.ExpiresAt, &usedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("refresh token not found")
+		}
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	return &t, nil
+}
+
+// MarkRefreshTokenUsed consumes a refresh token, it returns false when it was already used
+func (db *DB) MarkRefreshTokenUsed(id int) (bool, error) {
+	res, err := db.Db.Exec("UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+// UpdateTokenExpiry extends a session when its refresh token is rotated
+func (db *DB) UpdateTokenExpiry(tokenID string, expiresAt time.Time) error {
+	_, err := db.Db.Exec("UPDATE token SET expires_at = ? WHERE token = ?", expiresAt.UTC(), tokenID)
+	return err
+}
@@ -0,0 +1,298 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// searchFTS is set when SQLite has FTS5, the driver only includes it when built with
+// the sqlite_fts5 tag. Without it messages are searched with LIKE.
+var searchFTS bool
+
+// SearchQuery is a full-text search over the conversations of a user. At most one of
+// ChatID and GroupID restricts it to one conversation, zero times are open ends.
+type SearchQuery struct {
+	Text     string
+	ChatID   int
+	GroupID  int
+	SenderID int
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+// SearchResult is a message matching a search. Snippet is the part of the message
+// around the match, with the matched words between the Start and End markers.
+type SearchResult struct {
+	Kind      string    `json:"kind"` // "chat" or "group"
+	ChatID    int       `json:"chat_id,omitempty"`
+	GroupID   int       `json:"group_id,omitempty"`
+	MessageID int       `json:"message_id"`
+	SenderID  int       `json:"sender_id"`
+	Snippet   string    `json:"snippet"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// markers around the matched words of a snippet, messages can't contain control characters
+// (checkMessageContent refuses them) so they can't be faked
+const (
+	SnippetStart = "\x02"
+	SnippetEnd   = "\x03"
+)
+
+// the triggers keeping the search indexes up to date
+var searchTriggers = []string{
+	"messages_fts_insert", "messages_fts_delete", "messages_fts_update",
+	"group_messages_fts_insert", "group_messages_fts_delete", "group_messages_fts_update",
+}
+
+// InitMessageSearch creates the FTS5 indexes of chat and group messages and the triggers
+// keeping them up to date, indexing the history again when the triggers were missing.
+// They aren't part of the migrations since the driver may be built without FTS5; the
+// error then tells why the search falls back to LIKE, and the triggers are dropped so a
+// database once indexed can still be written to.
+func (db *DB) InitMessageSearch() error {
+	var indexed bool
+	err := db.Db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'messages_fts_insert')").Scan(&indexed)
+	if err != nil {
+		return err
+	}
+	if err := db.createSearchIndexes(!indexed); err != nil {
+		for _, trigger := range searchTriggers {
+			if _, dropErr := db.Db.Exec("DROP TRIGGER IF EXISTS " + trigger); dropErr != nil {
+				return dropErr
+			}
+		}
+		return err
+	}
+	searchFTS = true
+	return nil
+}
+
+func (db *DB) createSearchIndexes(rebuild bool) error {
+
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(content, content = 'messages', content_rowid = 'id')`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+			INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+		END`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS group_messages_fts USING fts5(text, content = 'group_messages', content_rowid = 'id')`,
+		`CREATE TRIGGER IF NOT EXISTS group_messages_fts_insert AFTER INSERT ON group_messages BEGIN
+			INSERT INTO group_messages_fts (rowid, text) VALUES (new.id, new.text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS group_messages_fts_delete AFTER DELETE ON group_messages BEGIN
+			INSERT INTO group_messages_fts (group_messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS group_messages_fts_update AFTER UPDATE OF text ON group_messages BEGIN
+			INSERT INTO group_messages_fts (group_messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+			INSERT INTO group_messages_fts (rowid, text) VALUES (new.id, new.text);
+		END`,
+	}
+	// an index created earlier is only readable when the driver has FTS5
+	statements = append(statements, "SELECT rowid FROM messages_fts LIMIT 1")
+	if rebuild {
+		statements = append(statements,
+			`INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')`,
+			`INSERT INTO group_messages_fts (group_messages_fts) VALUES ('rebuild')`)
+	}
+
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ftsQuery turns what a user typed into an FTS5 query matching every word, as a prefix,
+// so quotes and operators are taken literally
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"*`
+	}
+	return strings.Join(words, " ")
+}
+
+// likePatterns returns a LIKE pattern per word, with the wildcards of the words escaped
+func likePatterns(text string) []string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	var patterns []string
+	for _, word := range strings.Fields(text) {
+		patterns = append(patterns, "%"+replacer.Replace(word)+"%")
+	}
+	return patterns
+}
+
+// SearchMessages finds the messages of a user's chats and groups matching a search,
+// the latest first. Messages deleted for everyone or hidden by the user are left out,
+// and so are chats they declined.
+func (db *DB) SearchMessages(userID int, q SearchQuery) ([]SearchResult, bool, error) {
+	var parts []string
+	var args []interface{}
+
+	// filters shared by both kinds of messages, on the columns of alias
+	filters := func(alias string) string {
+		var where []string
+		if q.SenderID > 0 {
+			where = append(where, alias+".sender_id = ?")
+			args = append(args, q.SenderID)
+		}
+		if !q.From.IsZero() {
+			where = append(where, alias+".created_at >= ?")
+			args = append(args, q.From.UTC())
+		}
+		if !q.To.IsZero() {
+			where = append(where, alias+".created_at < ?")
+			args = append(args, q.To.UTC())
+		}
+		return strings.Join(append([]string{""}, where...), " AND ")
+	}
+	match := func(table, alias, column string) (string, string) {
+		if searchFTS {
+			args = append(args, ftsQuery(q.Text))
+			return "JOIN " + table + "_fts AS f ON f.rowid = " + alias + ".id",
+				"f." + table + "_fts MATCH ?"
+		}
+		var likes []string
+		for _, pattern := range likePatterns(q.Text) {
+			likes = append(likes, alias+"."+column+` LIKE ? ESCAPE '\'`)
+			args = append(args, pattern)
+		}
+		return "", strings.Join(likes, " AND ")
+	}
+	snippet := func(table, alias, column string) string {
+		if searchFTS {
+			return "snippet(" + table + "_fts, 0, char(2), char(3), '…', 16)"
+		}
+		return alias + "." + column
+	}
+
+	if q.GroupID == 0 {
+		join, cond := match("messages", "m", "content")
+		part := `SELECT 'chat' AS kind, m.chat_id AS conversation_id, m.id AS id, m.sender_id, ` + snippet("messages", "m", "content") + ` AS snippet, m.created_at AS created_at
+			FROM messages AS m ` + join + `
+			JOIN chat_members AS cm ON cm.chat_id = m.chat_id
+			WHERE ` + cond + ` AND cm.user_id = ? AND cm.status != 'declined' AND m.deleted_at IS NULL
+				AND m.id NOT IN (SELECT message_id FROM message_hidden WHERE user_id = ?)`
+		args = append(args, userID, userID)
+		if q.ChatID > 0 {
+			part += " AND m.chat_id = ?"
+			args = append(args, q.ChatID)
+		}
+		parts = append(parts, part+filters("m"))
+	}
+	if q.ChatID == 0 {
+		join, cond := match("group_messages", "gm", "text")
+		part := `SELECT 'group', gm.group_id, gm.id, gm.sender_id, ` + snippet("group_messages", "gm", "text") + `, gm.created_at
+			FROM group_messages AS gm ` + join + `
+			WHERE ` + cond + ` AND gm.group_id IN (SELECT group_id FROM group_members WHERE user_id = ? AND status IN ('approved', 'creator'))
+				AND gm.deleted_at IS NULL AND gm.id NOT IN (SELECT message_id FROM group_message_hidden WHERE user_id = ?)`
+		args = append(args, userID, userID)
+		if q.GroupID > 0 {
+			part += " AND gm.group_id = ?"
+			args = append(args, q.GroupID)
+		}
+		parts = append(parts, part+filters("gm"))
+	}
+
+	// one more row than asked tells whether there is a next page
+	args = append(args, q.Limit+1, q.Offset)
+	rows, err := db.Db.Query(strings.Join(parts, " UNION ALL ")+" ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		var conversationID int
+		var text sql.NullString
+		if err := rows.Scan(&r.Kind, &conversationID, &r.MessageID, &r.SenderID, &text, &r.CreatedAt); err != nil {
+			return nil, false, err
+		}
+		if r.Kind == "chat" {
+			r.ChatID = conversationID
+		} else {
+			r.GroupID = conversationID
+		}
+		r.Snippet = text.String
+		if !searchFTS {
+			r.Snippet = likeSnippet(text.String, q.Text)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(results) > q.Limit
+	if hasMore {
+		results = results[:q.Limit]
+	}
+	return results, hasMore, nil
+}
+
+// likeSnippet cuts a message around the first matched word and marks every match,
+// like the snippet function of FTS5 does
+func likeSnippet(text, query string) string {
+	const radius = 60
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	words := strings.Fields(strings.ToLower(query))
+
+	// matches as rune ranges, the first one to start wins where they overlap
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, word := range words {
+		w := []rune(word)
+		for i := 0; i+len(w) <= len(lower); i++ {
+			if string(lower[i:i+len(w)]) != word {
+				continue
+			}
+			for j := i; j < i+len(w); j++ {
+				marked[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+	if first == -1 {
+		first = 0
+	}
+
+	start, end := max(0, first-radius), min(len(runes), first+radius)
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if marked[i] && (i == start || !marked[i-1]) {
+			b.WriteString(SnippetStart)
+		}
+		b.WriteRune(runes[i])
+		if marked[i] && (i == end-1 || !marked[i+1]) {
+			b.WriteString(SnippetEnd)
+		}
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
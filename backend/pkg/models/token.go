@@ -0,0 +1,143 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Token struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	TokenID    string     `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// InsertToken stores a new session for a user with the device it was opened from
+func (db *DB) InsertToken(userID int, tokenID string, expiresAt time.Time, userAgent, ipAddress string) error {
+	now := time.Now().UTC()
+	_, err := db.Db.Exec("INSERT INTO token (user_id, token, expires_at, user_agent, ip_address, last_seen_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		userID, tokenID, expiresAt.UTC(), userAgent, ipAddress, now, now)
+	return err
+}
+
+const tokenColumns = "id, user_id, token, user_agent, ip_address, last_seen_at, expires_at, created_at"
+
+func scanToken(row interface{ Scan(...interface{}) error }) (*Token, error) {
+	var t Token
+	var lastSeen sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.TokenID, &t.UserAgent, &t.IPAddress, &lastSeen, &t.ExpiresAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		t.LastSeenAt = &lastSeen.Time
+	}
+	return &t, nil
+}
+
+// GetToken retrieves a session by its token ID
+func (db *DB) GetToken(tokenID string) (*Token, error) {
+	t, err := scanToken(db.Db.QueryRow("SELECT "+tokenColumns+" FROM token WHERE token = ?", tokenID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("token not found")
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetTokenByID retrieves a session by its row ID
+func (db *DB) GetTokenByID(id int) (*Token, error) {
+	t, err := scanToken(db.Db.QueryRow("SELECT "+tokenColumns+" FROM token WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("token not found")
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetUserTokens lists the active sessions of a user, most recently used first
+func (db *DB) GetUserTokens(userID int) ([]Token, error) {
+	rows, err := db.Db.Query("SELECT "+tokenColumns+" FROM token WHERE user_id = ? AND expires_at > ? ORDER BY COALESCE(last_seen_at, created_at) DESC",
+		userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, nil
+}
+
+// TouchToken records activity on a session, at most once a minute to spare writes
+func (db *DB) TouchToken(tokenID string) error {
+	now := time.Now().UTC()
+	_, err := db.Db.Exec("UPDATE token SET last_seen_at = ? WHERE token = ? AND (last_seen_at IS NULL OR last_seen_at < ?)",
+		now, tokenID, now.Add(-time.Minute))
+	return err
+}
+
+// DeleteUserTokensExcept revokes every session of a user but one, and returns the revoked token IDs
+func (db *DB) DeleteUserTokensExcept(userID int, keepTokenID string) ([]string, error) {
+	rows, err := db.Db.Query("SELECT token FROM token WHERE user_id = ? AND token != ?", userID, keepTokenID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokenIDs []string
+	for rows.Next() {
+		var tokenID string
+		if err := rows.Scan(&tokenID); err != nil {
+			return nil, err
+		}
+		tokenIDs = append(tokenIDs, tokenID)
+	}
+	rows.Close()
+
+	for _, tokenID := range tokenIDs {
+		if err := db.DeleteToken(tokenID); err != nil {
+			return nil, err
+		}
+	}
+	return tokenIDs, nil
+}
+
+// DeleteToken revokes a single session along with its refresh tokens
+func (db *DB) DeleteToken(tokenID string) error {
+	_, err := db.Db.Exec("DELETE FROM refresh_tokens WHERE session_id = ?", tokenID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Db.Exec("DELETE FROM token WHERE token = ?", tokenID)
+	return err
+}
+
+// DeleteExpiredTokens removes every session past its expiry and returns how many were purged
+func (db *DB) DeleteExpiredTokens() (int64, error) {
+	now := time.Now().UTC()
+	res, err := db.Db.Exec("DELETE FROM token WHERE expires_at < ?", now)
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.Db.Exec("DELETE FROM refresh_tokens WHERE expires_at < ? OR session_id NOT IN (SELECT token FROM token)", now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
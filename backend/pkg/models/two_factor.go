@@ -0,0 +1,157 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+type LoginChallenge struct {
+	ID        int
+	UserID    int
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+// GetTwoFactor returns the TOTP settings of a user, Secret is empty when none was set up
+func (db *DB) GetTwoFactor(userID int) (*TwoFactor, error) {
+	var t TwoFactor
+	var secret sql.NullString
+	err := db.Db.QueryRow("SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step FROM users WHERE id = ?", userID).
+		Scan(&secret, &t.Enabled, &t.LastStep)
+	if err != nil {
+		return nil, err
+	}
+	t.Secret = secret.String
+	return &t, nil
+}
+
+// SetPendingTOTPSecret stores a secret that becomes active once confirmed with a first code
+func (db *DB) SetPendingTOTPSecret(userID int, secret string) error {
+	_, err := db.Db.Exec("UPDATE users SET totp_secret = ?, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?", secret, userID)
+	return err
+}
+
+// EnableTOTP turns on two-factor authentication with the pending secret
+func (db *DB) EnableTOTP(userID int) error {
+	_, err := db.Db.Exec("UPDATE users SET totp_enabled_at = ? WHERE id = ? AND totp_secret IS NOT NULL", time.Now().UTC(), userID)
+	return err
+}
+
+// DisableTOTP turns off two-factor authentication and forgets its secret and recovery codes
+func (db *DB) DisableTOTP(userID int) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM login_challenges WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted code, it returns false when
+// that step or a later one was already used, so a code can't be replayed
+func (db *DB) UseTOTPStep(userID int, step int64) (bool, error) {
+	res, err := db.Db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+// ReplaceRecoveryCodes swaps every recovery code of a user for new ones
+func (db *DB) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)", userID, hash, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode consumes a recovery code, it returns false when no unused code matches
+func (db *DB) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	res, err := db.Db.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().UTC(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (db *DB) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := db.Db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// InsertLoginChallenge stores the hash of a challenge issued after the password step of a login
+func (db *DB) InsertLoginChallenge(userID int, tokenHash string, expiresAt time.Time) error {
+	_, err := db.Db.Exec("INSERT INTO login_challenges (user_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)",
+		userID, tokenHash, expiresAt.UTC(), time.Now().UTC())
+	return err
+}
+
+// GetLoginChallenge retrieves a login challenge by its hash
+func (db *DB) GetLoginChallenge(tokenHash string) (*LoginChallenge, error) {
+	var c LoginChallenge
+	err := db.Db.QueryRow("SELECT id, user_id, attempts, expires_at FROM login_challenges WHERE token_hash = ?", tokenHash).
+		Scan(&c.ID, &c.UserID, &c.Attempts, &c.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("login challenge not found")
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CountLoginChallengeAttempt records a wrong code against a challenge
+func (db *DB) CountLoginChallengeAttempt(id int) error {
+	_, err := db.Db.Exec("UPDATE login_challenges SET attempts = attempts + 1 WHERE id = ?", id)
+	return err
+}
+
+// DeleteLoginChallenge consumes a challenge, it returns false when it was already consumed
+func (db *DB) DeleteLoginChallenge(id int) (bool, error) {
+	res, err := db.Db.Exec("DELETE FROM login_challenges WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+// DeleteExpiredLoginChallenges removes challenges that were never completed
+func (db *DB) DeleteExpiredLoginChallenges() error {
+	_, err := db.Db.Exec("DELETE FROM login_challenges WHERE expires_at < ?", time.Now().UTC())
+	return err
+}
@@ -5,8 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
@@ -15,17 +13,19 @@ type User struct {
 	Firstname   string         `json:"firstname"`
 	Lastname    string         `json:"lastname"`
 	Email       string         `json:"email"`
-	Password    string         `json:"password"`
+	Password    string         `json:"-"`
 	DateOfBirth string         `json:"date_of_birth"`
 	Gender      string         `json:"gender"`
 	AboutMe     string         `json:"about_me"`
 	Avatar      *string        `json:"avatar"`
 	IsPublic    bool           `json:"is_public"`
+	// false until the owner opens the link sent to their email
+	EmailVerified bool `json:"email_verified"`
 }
 
 // Insert a new user into the database
 func (db *DB) Insert(u User) (int, error) {
-	hashedPass, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hashedPass, err := Hasher.Hash(u.Password)
 	if err != nil {
 		return 0, errors.New("failed to hash password")
 	}
@@ -50,8 +50,8 @@ func (db *DB) Insert(u User) (int, error) {
 
 func (db *DB) GetUserByLogin(email string) (*User, error) {
 	user := &User{}
-	err := db.Db.QueryRow(`SELECT id, email, password_hash, first_name, last_name, date_of_birth, avatar, nickname, about_me, is_public FROM users WHERE email = ? OR nickName = ?`, email, email).
-		Scan(&user.ID, &user.Email, &user.Password, &user.Firstname, &user.Lastname, &user.DateOfBirth, &user.Avatar, &user.Nickname, &user.AboutMe, &user.IsPublic)
+	err := db.Db.QueryRow(`SELECT id, email, password_hash, first_name, last_name, date_of_birth, avatar, nickname, about_me, is_public, email_verified_at IS NOT NULL FROM users WHERE email = ? OR nickName = ?`, email, email).
+		Scan(&user.ID, &user.Email, &user.Password, &user.Firstname, &user.Lastname, &user.DateOfBirth, &user.Avatar, &user.Nickname, &user.AboutMe, &user.IsPublic, &user.EmailVerified)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("user not found")
@@ -93,9 +93,14 @@ func (db *DB) CheckIsExistNickNameInDB(nickName string) (int, error) {
 	return http.StatusBadRequest, errors.New("this nick-name already exist")
 }
 
-func (u *User) ComparePassword(password string) bool {
-	// err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-	return true
+// ComparePassword checks a password against the stored hash, and reports whether the hash should be upgraded
+func (u *User) ComparePassword(password string) (bool, bool) {
+	ok, needsRehash, err := VerifyPassword(u.Password, password)
+	if err != nil {
+		fmt.Println(err)
+		return false, false
+	}
+	return ok, needsRehash
 }
 
 func (db *DB) GetUserInfo(userID int) (User, error) {
@@ -148,7 +153,7 @@ func (db *DB) UpdatePrivacy(userID int) error {
 
 func (db *DB) GetUserByID(id int) (*User, error) {
 	var user User
-	err := Db.Db.QueryRow("SELECT id, email, password_hash, first_name, last_name, date_of_birth, avatar, nickname, about_me, is_public FROM users WHERE id = ?", id).Scan(&user.ID, &user.Email, &user.Password, &user.Firstname, &user.Lastname, &user.DateOfBirth, &user.Avatar, &user.Nickname, &user.AboutMe, &user.IsPublic)
+	err := Db.Db.QueryRow("SELECT id, email, password_hash, first_name, last_name, date_of_birth, avatar, nickname, about_me, is_public, email_verified_at IS NOT NULL FROM users WHERE id = ?", id).Scan(&user.ID, &user.Email, &user.Password, &user.Firstname, &user.Lastname, &user.DateOfBirth, &user.Avatar, &user.Nickname, &user.AboutMe, &user.IsPublic, &user.EmailVerified)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// WSTicket lets a client open a WebSocket without putting its token in the URL
+type WSTicket struct {
+	UserID     int
+	SessionID  string
+	APITokenID int
+	ExpiresAt  time.Time
+}
+
+// InsertWSTicket stores the hash of a ticket, with the session or api token it was issued for
+func (db *DB) InsertWSTicket(ticketHash string, ticket WSTicket) error {
+	_, err := db.Db.Exec("INSERT INTO ws_tickets (ticket_hash, user_id, session_id, api_token_id, expires_at) VALUES (?, ?, ?, ?, ?)",
+		ticketHash, ticket.UserID, ticket.SessionID, ticket.APITokenID, ticket.ExpiresAt.UTC())
+	return err
+}
+
+// UseWSTicket consumes a ticket, so it can only open one socket
+func (db *DB) UseWSTicket(ticketHash string) (*WSTicket, error) {
+	var t WSTicket
+	err := db.Db.QueryRow("DELETE FROM ws_tickets WHERE ticket_hash = ? RETURNING user_id, session_id, api_token_id, expires_at", ticketHash).
+		Scan(&t.UserID, &t.SessionID, &t.APITokenID, &t.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("ws ticket not found")
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteExpiredWSTickets removes tickets that were never used
+func (db *DB) DeleteExpiredWSTickets() error {
+	_, err := db.Db.Exec("DELETE FROM ws_tickets WHERE expires_at < ?", time.Now().UTC())
+	return err
+}
@@ -0,0 +1,113 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"social-network/pkg/models"
+)
+
+// APITokenPrefix tells personal access tokens apart from session JWTs in an Authorization header
+const APITokenPrefix = "snp_"
+
+const (
+	MaxAPITokensPerUser = 20
+	maxAPITokenName     = 64
+)
+
+// APIScopes are the permissions a personal access token can be granted
+var APIScopes = map[string]string{
+	"posts:read":          "read posts and comments",
+	"posts:write":         "create posts, comments and reactions",
+	"groups:read":         "read groups, their members and events",
+	"groups:manage":       "create groups, invite, answer requests and events",
+	"messages:read":       "read private and group messages",
+	"messages:send":       "send private and group messages",
+	"notifications:read":  "read notifications",
+	"notifications:write": "mark notifications read or unread, archive and delete them",
+}
+
+var (
+	ErrInvalidAPIToken   = errors.New("invalid, expired or revoked api token")
+	ErrTooManyAPITokens  = fmt.Errorf("a user can't have more than %d api tokens", MaxAPITokensPerUser)
+	ErrInvalidTokenName  = fmt.Errorf("name is required and must be at most %d characters", maxAPITokenName)
+	ErrTokenExpiryPassed = errors.New("expires_at must be in the future")
+)
+
+// ScopeError reports a scope that doesn't exist
+type ScopeError struct {
+	Scope string
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("unknown scope %q", e.Scope)
+}
+
+// IsAPIToken reports whether a bearer token is a personal access token
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// CreateAPIToken issues a personal access token and returns it in clear, only its hash is kept
+func CreateAPIToken(userID int, name string, scopes []string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPITokenName {
+		return "", nil, ErrInvalidTokenName
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, ErrTokenExpiryPassed
+	}
+
+	seen := make(map[string]bool)
+	var granted []string
+	for _, scope := range scopes {
+		if _, ok := APIScopes[scope]; !ok {
+			return "", nil, &ScopeError{Scope: scope}
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			granted = append(granted, scope)
+		}
+	}
+	sort.Strings(granted)
+
+	existing, err := models.Db.GetUserAPITokens(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(existing) >= MaxAPITokensPerUser {
+		return "", nil, ErrTooManyAPITokens
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := APITokenPrefix + secret
+
+	stored, err := models.Db.InsertAPIToken(userID, name, hashToken(token), granted, expiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, stored, nil
+}
+
+// CheckAPIToken returns the personal access token matching a bearer token, and records its use
+func CheckAPIToken(token string) (*models.APIToken, error) {
+	stored, err := models.Db.GetAPITokenByHash(hashToken(token))
+	if err != nil {
+		if err.Error() == "api token not found" {
+			return nil, ErrInvalidAPIToken
+		}
+		return nil, err
+	}
+	if stored.ExpiresAt != nil && time.Now().After(*stored.ExpiresAt) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	models.Db.TouchAPIToken(stored.ID)
+	return stored, nil
+}
@@ -0,0 +1,233 @@
+package tools
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"social-network/pkg/models"
+)
+
+const (
+	// thumbnails fit in a square of this many pixels
+	thumbnailSize = 320
+	// larger images are stored without a thumbnail rather than decoded
+	maxThumbnailSourcePixels = 12_000_000
+	// thumbnails made at the same time, every one holds a decoded image in memory
+	maxConcurrentThumbnails = 2
+	// uploads never sent with a message are deleted after this long
+	unsentAttachmentTTL = 24 * time.Hour
+)
+
+// AttachmentsDir holds the files of chat attachments. It must not be served as is,
+// downloads go through the handlers so only members can read them.
+var AttachmentsDir = "attachments"
+
+// thumbnailSlots bounds the images decoded at once, uploads wait for a free slot
+var thumbnailSlots = make(chan struct{}, maxConcurrentThumbnails)
+
+// MaxAttachmentSize is the largest file accepted as a chat attachment, in bytes
+var MaxAttachmentSize int64 = 25 << 20
+
+// a user can keep this many uploads not sent yet, and this many bytes of them, until they
+// send them or they expire
+const (
+	MaxUnsentAttachments           = 20
+	MaxUnsentAttachmentBytes int64 = 200 << 20
+)
+
+// AttachmentTypes are the content types accepted as chat attachments, as sniffed from the file
+var AttachmentTypes = map[string]bool{
+	"image/jpeg":                true,
+	"image/png":                 true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"application/pdf":           true,
+	"text/plain; charset=utf-8": true,
+	"application/zip":           true,
+	"audio/mpeg":                true,
+	"audio/wave":                true,
+	"video/mp4":                 true,
+	"video/webm":                true,
+}
+
+// LoadAttachmentSettings reads ATTACHMENTS_DIR and MAX_ATTACHMENT_SIZE, in megabytes,
+// and creates the attachments directory
+func LoadAttachmentSettings() error {
+	if dir := os.Getenv("ATTACHMENTS_DIR"); dir != "" {
+		AttachmentsDir = dir
+	}
+	if value := os.Getenv("MAX_ATTACHMENT_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid MAX_ATTACHMENT_SIZE %q", value)
+		}
+		MaxAttachmentSize = int64(size) << 20
+	}
+	return os.MkdirAll(AttachmentsDir, 0o750)
+}
+
+// AttachmentPath is where the file of an attachment is stored
+func AttachmentPath(storageKey string) string {
+	return filepath.Join(AttachmentsDir, storageKey)
+}
+
+// ThumbnailPath is where the thumbnail of an image attachment is stored
+func ThumbnailPath(storageKey string) string {
+	return filepath.Join(AttachmentsDir, storageKey+"_thumb.jpg")
+}
+
+// SaveAttachment writes an uploaded file under its storage key
+func SaveAttachment(src io.Reader, storageKey string) error {
+	out, err := os.OpenFile(AttachmentPath(storageKey), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		os.Remove(AttachmentPath(storageKey))
+		return err
+	}
+	return out.Close()
+}
+
+// RemoveAttachmentFiles deletes the file of an attachment and its thumbnail
+func RemoveAttachmentFiles(storageKey string) {
+	for _, path := range []string{AttachmentPath(storageKey), ThumbnailPath(storageKey)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Println("failed to remove attachment file:", err)
+		}
+	}
+}
+
+// MakeThumbnail reads the size of a stored image and writes its thumbnail. ok is false
+// for images Go can't decode, like WebP, and for images too large to be decoded safely.
+func MakeThumbnail(storageKey string) (width, height int, ok bool, err error) {
+	in, err := os.Open(AttachmentPath(storageKey))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer in.Close()
+
+	config, _, err := image.DecodeConfig(in)
+	if err != nil {
+		return 0, 0, false, nil
+	}
+	if config.Width*config.Height > maxThumbnailSourcePixels {
+		return config.Width, config.Height, false, nil
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, false, err
+	}
+
+	thumbnailSlots <- struct{}{}
+	defer func() { <-thumbnailSlots }()
+	src, _, err := image.Decode(in)
+	if err != nil {
+		return config.Width, config.Height, false, nil
+	}
+
+	out, err := os.OpenFile(ThumbnailPath(storageKey), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if err := jpeg.Encode(out, scaleDown(src, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		out.Close()
+		os.Remove(ThumbnailPath(storageKey))
+		return 0, 0, false, err
+	}
+	return config.Width, config.Height, true, out.Close()
+}
+
+// scaleDown fits an image in a square by averaging the source pixels covered by each
+// thumbnail pixel, over a white background since JPEG has no transparency
+func scaleDown(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	pixel := pixelReader(src)
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+max((x+1)*w/tw, x*w/tw+1)
+			var r, g, bl, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := pixel(sx, sy)
+					// blend on white, the colors are premultiplied by alpha
+					r += uint64(pr + 0xffff - pa)
+					g += uint64(pg + 0xffff - pa)
+					bl += uint64(pb + 0xffff - pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: 0xffff})
+		}
+	}
+	return dst
+}
+
+// pixelReader returns the premultiplied 16-bit color of a pixel like image.At does, reading
+// the formats the decoders return straight from their buffers instead of through a color
+func pixelReader(src image.Image) func(x, y int) (r, g, b, a uint32) {
+	switch img := src.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			yi, ci := img.YOffset(x, y), img.COffset(x, y)
+			return color.YCbCr{Y: img.Y[yi], Cb: img.Cb[ci], Cr: img.Cr[ci]}.RGBA()
+		}
+	case *image.RGBA:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			p := img.Pix[img.PixOffset(x, y):]
+			return uint32(p[0]) * 0x101, uint32(p[1]) * 0x101, uint32(p[2]) * 0x101, uint32(p[3]) * 0x101
+		}
+	case *image.NRGBA:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			p := img.Pix[img.PixOffset(x, y):]
+			a := uint32(p[3]) * 0x101
+			return uint32(p[0]) * a / 0xff, uint32(p[1]) * a / 0xff, uint32(p[2]) * a / 0xff, a
+		}
+	case *image.Gray:
+		return func(x, y int) (uint32, uint32, uint32, uint32) {
+			v := uint32(img.Pix[img.PixOffset(x, y)]) * 0x101
+			return v, v, v, 0xffff
+		}
+	}
+	return func(x, y int) (uint32, uint32, uint32, uint32) {
+		return src.At(x, y).RGBA()
+	}
+}
+
+// PurgeOrphanAttachments deletes the uploads never sent with a message and the
+// attachments of messages that were deleted, with their files
+func PurgeOrphanAttachments() {
+	keys, err := models.Db.DeleteOrphanAttachments(time.Now().UTC().Add(-unsentAttachmentTTL))
+	if err != nil {
+		log.Println("failed to purge attachments:", err)
+		return
+	}
+	for _, key := range keys {
+		RemoveAttachmentFiles(key)
+	}
+	if len(keys) > 0 {
+		log.Printf("purged %d orphan attachments\n", len(keys))
+	}
+}
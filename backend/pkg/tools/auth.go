@@ -1,14 +1,26 @@
 package tools
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"strings"
 
 	"social-network/pkg/models"
 )
 
-// GetUserFromRequest extracts the user from the Authorization header (JWT)
+// GetUserFromRequest returns the user set by the token middleware, or
+// extracts it from the Authorization header (JWT)
 func GetUserFromRequest(r *http.Request) (*models.User, int, error) {
+	if userID, ok := r.Context().Value("userID").(int); ok {
+		user, err := models.Db.GetUserByID(userID)
+		if err != nil {
+			return nil, http.StatusUnauthorized, err
+		}
+		return user, http.StatusOK, nil
+	}
+
 	token := r.Header.Get("Authorization")
 	if token == "" {
 		return nil, http.StatusUnauthorized, http.ErrNoCookie
@@ -26,3 +38,72 @@ func GetUserFromRequest(r *http.Request) (*models.User, int, error) {
 	}
 	return user, http.StatusOK, nil
 }
+
+// TrustedProxies are the addresses allowed to tell the client IP in X-Forwarded-For,
+// the header of anyone else is ignored
+var TrustedProxies []*net.IPNet
+
+// LoadProxySettings reads TRUSTED_PROXIES, comma separated IP addresses or CIDR ranges
+func LoadProxySettings() error {
+	value := os.Getenv("TRUSTED_PROXIES")
+	TrustedProxies = nil
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			entry = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+		}
+		TrustedProxies = append(TrustedProxies, network)
+	}
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientInfo returns the user agent and IP address a request came from. X-Forwarded-For
+// is only read when the request comes from a trusted proxy, and then the client is the
+// last address in it that isn't one of the proxies, the ones before can be made up.
+func ClientInfo(r *http.Request) (string, string) {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	peer := net.ParseIP(ip)
+	if peer == nil || !isTrustedProxy(peer) {
+		return r.UserAgent(), ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop.String()
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return r.UserAgent(), ip
+}
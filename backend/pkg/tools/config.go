@@ -1,8 +1,41 @@
 package tools
 
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"social-network/pkg/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
 var SecretKey = []byte("mihit-kerzazi")
 
 type ErrorApi struct {
 	ErrorMessage string `json:"error_message"`
 	ErrorCode    int    `json:"error_code"`
+	Code         string `json:"code,omitempty"`
+}
+
+// LoadPasswordHasher picks the algorithm for new password hashes from
+// PASSWORD_HASHER ("bcrypt" or "argon2id") and BCRYPT_COST
+func LoadPasswordHasher() error {
+	switch os.Getenv("PASSWORD_HASHER") {
+	case "", "bcrypt":
+		cost := bcrypt.DefaultCost
+		if value := os.Getenv("BCRYPT_COST"); value != "" {
+			var err error
+			cost, err = strconv.Atoi(value)
+			if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+				return fmt.Errorf("invalid BCRYPT_COST %q", value)
+			}
+		}
+		models.Hasher = models.BcryptHasher{Cost: cost}
+	case "argon2id":
+		models.Hasher = models.DefaultArgon2id
+	default:
+		return fmt.Errorf("unknown PASSWORD_HASHER %q", os.Getenv("PASSWORD_HASHER"))
+	}
+	return nil
 }
@@ -0,0 +1,49 @@
+package tools
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"social-network/pkg/models"
+)
+
+// openTestDB points models.Db at a fresh migrated database for the duration of a test
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations := &migrate.FileMigrationSource{Dir: "../db/migrations/sqlite"}
+	if _, err := migrate.Exec(db, "sqlite3", migrations, migrate.Up); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := models.Db
+	models.Db = models.InitializeDb(db)
+	t.Cleanup(func() { models.Db = previous })
+}
+
+// insertTestUser creates a bare account and returns its id
+func insertTestUser(t *testing.T, email string) int {
+	t.Helper()
+
+	res, err := models.Db.Db.Exec(`
+		INSERT INTO users (email, nickname, first_name, last_name, password_hash, date_of_birth, gender, about_me)
+		VALUES (?, 'tester', 'Test', 'User', 'x', '2000-01-01', 'other', '')`, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id)
+}
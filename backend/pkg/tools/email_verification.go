@@ -0,0 +1,142 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"social-network/pkg/models"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	EmailVerificationLifetime = 48 * time.Hour
+	// the minimum delay between two verification emails to the same user
+	VerificationResendInterval = 2 * time.Minute
+
+	emailVerificationPurpose = "verify_email"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+	ErrVerificationRateLimited  = errors.New("a verification email was sent recently, please wait before asking again")
+	ErrAlreadyVerified          = errors.New("email already verified")
+)
+
+// how long an account may stay unverified before it is deleted
+var UnverifiedAccountTTL = 7 * 24 * time.Hour
+
+// EmailVerificationClaims is the payload of a verification link. It is signed with
+// the same keyring as access tokens, the purpose keeps one from passing as the other.
+type EmailVerificationClaims struct {
+	UserID    int    `json:"id"`
+	Email     string `json:"email"`
+	Purpose   string `json:"purpose"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+}
+
+func (c EmailVerificationClaims) Valid() error {
+	if c.Purpose != emailVerificationPurpose || c.UserID == 0 || c.Email == "" {
+		return ErrInvalidVerificationToken
+	}
+	if time.Now().Unix() > c.ExpiresAt+int64(tokenLeeway.Seconds()) {
+		return ErrInvalidVerificationToken
+	}
+	return nil
+}
+
+// SendVerificationEmail emails a signed verification link, at most once per VerificationResendInterval
+func SendVerificationEmail(userID int) error {
+	user, err := models.Db.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+
+	claimed, err := models.Db.ClaimVerificationEmail(userID, VerificationResendInterval)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrVerificationRateLimited
+	}
+
+	key, err := Keys.Active()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(key.Method, EmailVerificationClaims{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Purpose:   emailVerificationPurpose,
+		ExpiresAt: now.Add(EmailVerificationLifetime).Unix(),
+		IssuedAt:  now.Unix(),
+	})
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Sign)
+	if err != nil {
+		return err
+	}
+
+	link := AppURL + "/verify-email?token=" + url.QueryEscape(signed)
+	return Mail.Send(Email{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nWelcome to Social Network! Open this link within %d hours to confirm your email address:\n\n%s\n\n"+
+			"Until then you can't post, send messages or create groups. Unconfirmed accounts are deleted after %d days.\n",
+			user.Firstname, int(EmailVerificationLifetime.Hours()), link, int(UnverifiedAccountTTL.Hours()/24)),
+	})
+}
+
+// VerifyEmail checks a verification link and marks the email of its user as verified
+func VerifyEmail(tokenString string) error {
+	var claims EmailVerificationClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, Keys.keyFunc)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	verified, err := models.Db.MarkEmailVerified(claims.UserID, claims.Email)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		// the account was deleted or its email changed since the link was sent
+		return ErrInvalidVerificationToken
+	}
+	return nil
+}
+
+// LoadVerificationSettings reads UNVERIFIED_ACCOUNT_TTL, a duration such as "72h"
+func LoadVerificationSettings() error {
+	value := os.Getenv("UNVERIFIED_ACCOUNT_TTL")
+	if value == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return fmt.Errorf("invalid UNVERIFIED_ACCOUNT_TTL %q", value)
+	}
+	UnverifiedAccountTTL = ttl
+	return nil
+}
+
+// PurgeUnverifiedAccounts deletes accounts that were never verified within UnverifiedAccountTTL
+func PurgeUnverifiedAccounts() {
+	purged, err := models.Db.DeleteUnverifiedUsers(time.Now().Add(-UnverifiedAccountTTL))
+	if err != nil {
+		log.Println("failed to purge unverified accounts:", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("purged %d unverified accounts\n", purged)
+	}
+}
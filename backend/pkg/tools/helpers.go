@@ -51,10 +51,16 @@ func JSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
 }
 
 func ErrorJSONResponse(w http.ResponseWriter, statusCode int, message string) {
+	ErrorCodeJSONResponse(w, statusCode, "", message)
+}
+
+// ErrorCodeJSONResponse is ErrorJSONResponse with a machine readable code clients can branch on
+func ErrorCodeJSONResponse(w http.ResponseWriter, statusCode int, code string, message string) {
 	w.WriteHeader(statusCode)
 	var errResponse = ErrorApi{
 		ErrorMessage: message,
 		ErrorCode:    statusCode,
+		Code:         code,
 	}
 	err := json.NewEncoder(w).Encode(errResponse)
 	if err != nil {
@@ -0,0 +1,16 @@
+package tools
+
+import "time"
+
+// RunEvery runs job once right away and then every interval in the background
+func RunEvery(interval time.Duration, job func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			job()
+			<-ticker.C
+		}
+	}()
+}
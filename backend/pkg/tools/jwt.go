@@ -1,72 +1,227 @@
 package tools
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"social-network/pkg/models"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 )
 
-// store valid tokens with their claims
-var activeTokens = make(map[string]*TokenClaims)
+const (
+	// access tokens are short lived, clients renew them with their refresh token
+	AccessTokenLifetime = 15 * time.Minute
+	// a session ends when its refresh token hasn't been used for this long
+	RefreshTokenLifetime = 30 * 24 * time.Hour
+)
+
+var (
+	ErrTokenExpired        = errors.New("token expired")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used, session revoked")
+)
 
-// Token signing key
-var TokenSigningKey = []byte("your-secret-key")
+// tolerated clock difference between the processes issuing and checking tokens
+const tokenLeeway = 30 * time.Second
 
 type TokenClaims struct {
-	UserID    int       `json:"id"`
-	Username  string    `json:"user-name"`
-	ExpiresAt time.Time `json:"exp"`
-	TokenID   string    `json:"tid"`
+	UserID    int    `json:"id"`
+	Username  string `json:"user-name"`
+	TokenID   string `json:"tid"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
 }
 
-// create a new token for a user
-func GenerateJWTToken(userId int, userEmail string) (string, error) {
+// Valid implements jwt.Claims, every time claim is required
+func (c *TokenClaims) Valid() error {
+	if c.ExpiresAt == 0 || c.IssuedAt == 0 || c.NotBefore == 0 {
+		return errors.New("missing exp, iat or nbf claim")
+	}
+
+	now := time.Now()
+	leeway := int64(tokenLeeway.Seconds())
+
+	if c.ExpiresAt <= c.IssuedAt {
+		return errors.New("token expires before it was issued")
+	}
+	if now.Unix() > c.ExpiresAt+leeway {
+		return ErrTokenExpired
+	}
+	if c.IssuedAt > now.Unix()+leeway {
+		return errors.New("token used before issued")
+	}
+	if c.NotBefore > now.Unix()+leeway {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+}
+
+// start a new session for a user and return its first token pair
+func GenerateJWTToken(userId int, userName, userAgent, ipAddress string) (*TokenPair, error) {
 	tokenID := uuid.New().String()
-	claims := &TokenClaims{
-		UserID:    userId,
-		Username:  userEmail,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
-		TokenID:   tokenID,
+
+	// persist the session so it survives restarts and is shared between processes
+	err := models.Db.InsertToken(userId, tokenID, time.Now().Add(RefreshTokenLifetime), userAgent, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokenPair(userId, userName, tokenID)
+}
+
+// exchange a refresh token for a new pair, the refresh token can only be used once
+func RefreshTokenPair(refreshToken string) (*TokenPair, error) {
+	stored, err := models.Db.GetRefreshToken(hashToken(refreshToken))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
 	}
 
-	jsonData, err := json.Marshal(claims)
+	// a refresh token used twice was stolen, kill the whole token family
+	if stored.UsedAt != nil {
+		models.Db.DeleteToken(stored.SessionID)
+		return nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if _, err := models.Db.GetToken(stored.SessionID); err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	consumed, err := models.Db.MarkRefreshTokenUsed(stored.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		models.Db.DeleteToken(stored.SessionID)
+		return nil, ErrRefreshTokenReused
+	}
+
+	user, err := models.Db.GetUserByID(stored.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	userName := user.Nickname.String
+	if userName == "" {
+		userName = "Anonymous"
+	}
+
+	err = models.Db.UpdateTokenExpiry(stored.SessionID, time.Now().Add(RefreshTokenLifetime))
+	if err != nil {
+		return nil, err
+	}
+	models.Db.TouchToken(stored.SessionID)
+
+	return issueTokenPair(stored.UserID, userName, stored.SessionID)
+}
+
+// sign an access token and create a refresh token for an existing session
+func issueTokenPair(userId int, userName, tokenID string) (*TokenPair, error) {
+	accessToken, err := signAccessToken(userId, userName, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = models.Db.InsertRefreshToken(userId, tokenID, hashToken(refreshToken), time.Now().Add(RefreshTokenLifetime))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(AccessTokenLifetime.Seconds()),
+	}, nil
+}
+
+func signAccessToken(userId int, userName, tokenID string) (string, error) {
+	key, err := Keys.Active()
 	if err != nil {
 		return "", err
 	}
 
-	token := base64.URLEncoding.EncodeToString(jsonData)
+	now := time.Now()
+	claims := &TokenClaims{
+		UserID:    userId,
+		Username:  userName,
+		TokenID:   tokenID,
+		ExpiresAt: now.Add(AccessTokenLifetime).Unix(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
 
-	activeTokens[tokenID] = claims
+	return token.SignedString(key.Sign)
+}
 
-	return token, nil
+// generate an opaque random token
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// only hashes of opaque tokens are stored, so a database leak can't be replayed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // validate a token and returns the user ID
 func CheckIsTokenValid(tokenString string) (int, error) {
+	claims, err := CheckTokenClaims(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
+
+// validate an Authorization header and returns the claims of its token
+func CheckTokenClaims(tokenString string) (*TokenClaims, error) {
 	if tokenString == "" {
-		return 0, errors.New("missing authorization header")
+		return nil, errors.New("missing authorization header")
 	}
 
 	// Strip "Bearer " prefix if present
 	if len(tokenString) >= len("Bearer ") {
 		tokenString = tokenString[len("Bearer "):]
 	} else {
-		return 0, errors.New("unauthorized")
+		return nil, errors.New("unauthorized")
 	}
 
 	claims, err := validateAndGetClaims(tokenString)
 	if err != nil {
-		return 0, fmt.Errorf("unauthorized: %v", err)
+		return nil, fmt.Errorf("unauthorized: %w", err)
 	}
 
-	return claims.UserID, nil
+	return claims, nil
 }
 
 // validate a token and returns the associated user
@@ -95,26 +250,43 @@ func ValidateToken(tokenString string) (*models.User, bool, error) {
 
 // validate a token and returns its claims
 func validateAndGetClaims(tokenString string) (*TokenClaims, error) {
-	jsonData, err := base64.URLEncoding.DecodeString(tokenString)
+	var claims TokenClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, Keys.keyFunc)
 	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner != nil {
+			return nil, ve.Inner
+		}
 		return nil, fmt.Errorf("invalid token format")
 	}
 
-	var claims TokenClaims
-	if err := json.Unmarshal(jsonData, &claims); err != nil {
-		return nil, fmt.Errorf("invalid token data")
+	// Check if the session still exists in the database
+	session, err := models.Db.GetToken(claims.TokenID)
+	if err != nil {
+		return nil, fmt.Errorf("token not found or expired")
 	}
 
-	// Check if token exists in active tokens
-	storedClaims, exists := activeTokens[claims.TokenID]
-	if !exists {
-		return nil, fmt.Errorf("token not found or expired")
+	if session.UserID != claims.UserID {
+		return nil, fmt.Errorf("invalid token data")
 	}
 
-	if time.Now().After(claims.ExpiresAt) {
-		delete(activeTokens, claims.TokenID)
-		return nil, fmt.Errorf("token expired")
+	if time.Now().After(session.ExpiresAt) {
+		models.Db.DeleteToken(claims.TokenID)
+		return nil, fmt.Errorf("session expired")
 	}
 
-	return storedClaims, nil
+	models.Db.TouchToken(claims.TokenID)
+
+	return &claims, nil
+}
+
+// remove expired sessions from the database
+func PurgeExpiredTokens() {
+	purged, err := models.Db.DeleteExpiredTokens()
+	if err != nil {
+		log.Println("failed to purge expired tokens:", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("purged %d expired tokens\n", purged)
+	}
 }
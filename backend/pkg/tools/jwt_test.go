@@ -0,0 +1,53 @@
+package tools
+
+import (
+	"testing"
+
+	"social-network/pkg/models"
+)
+
+func useTestKeyring(t *testing.T) {
+	t.Helper()
+
+	key, err := NewHMACKey("test", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := Keys
+	Keys = NewKeyring()
+	Keys.Add(key, true)
+	t.Cleanup(func() { Keys = previous })
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	openTestDB(t)
+	useTestKeyring(t)
+	userID := insertTestUser(t, "refresh@example.com")
+
+	first, err := GenerateJWTToken(userID, "tester", "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := CheckTokenClaims("Bearer " + first.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := RefreshTokenPair(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("first refresh: %v", err)
+	}
+
+	if _, err := RefreshTokenPair(first.RefreshToken); err != ErrRefreshTokenReused {
+		t.Fatalf("reused refresh token: err = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if _, err := models.Db.GetToken(claims.TokenID); err == nil {
+		t.Error("session survived a reused refresh token")
+	}
+	if _, err := RefreshTokenPair(second.RefreshToken); err == nil {
+		t.Error("refresh token of a revoked session was accepted")
+	}
+	if _, err := CheckTokenClaims("Bearer " + second.AccessToken); err == nil {
+		t.Error("access token of a revoked session was accepted")
+	}
+}
@@ -0,0 +1,188 @@
+package tools
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// a single key used to sign and verify tokens
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Sign   interface{}
+	Verify interface{}
+}
+
+// Keyring holds every key that may verify a token, and the one used to sign new ones.
+// Rotating means adding a new active key while keeping the old one until its tokens expire.
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	active string
+}
+
+// the keyring used for every token issued by the server
+var Keys = NewKeyring()
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string]*SigningKey),
+	}
+}
+
+// Add registers a key, and makes it the signing key when active is true
+func (k *Keyring) Add(key *SigningKey, active bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys[key.ID] = key
+	if active || k.active == "" {
+		k.active = key.ID
+	}
+}
+
+// Remove retires a key, every token signed with it becomes invalid
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if id == k.active {
+		return errors.New("can't remove the active signing key")
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// Active returns the key new tokens are signed with
+func (k *Keyring) Active() (*SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[k.active]
+	if !ok {
+		return nil, errors.New("no active signing key")
+	}
+	return key, nil
+}
+
+// Get returns the key matching a kid header
+func (k *Keyring) Get(id string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// keyFunc resolves the verification key of a token from its kid header
+func (k *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("missing key id")
+	}
+
+	key, ok := k.Get(kid)
+	if !ok {
+		return nil, errors.New("unknown key id")
+	}
+
+	// refuse tokens whose alg doesn't match the key, to avoid algorithm confusion
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Method.Alg())
+	}
+	return key.Verify, nil
+}
+
+func NewHMACKey(id string, secret []byte) (*SigningKey, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("key %q: HMAC secret must be at least 32 bytes", id)
+	}
+	return &SigningKey{
+		ID:     id,
+		Method: jwt.SigningMethodHS256,
+		Sign:   secret,
+		Verify: secret,
+	}, nil
+}
+
+func NewEd25519Key(id string, seed []byte) (*SigningKey, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("key %q: Ed25519 seed must be %d bytes", id, ed25519.SeedSize)
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	return &SigningKey{
+		ID:     id,
+		Method: jwt.SigningMethodEdDSA,
+		Sign:   private,
+		Verify: private.Public(),
+	}, nil
+}
+
+// LoadKeyring fills Keys from the environment:
+//
+//	JWT_KEYS="2024-01:HS256:<base64 secret>,2024-06:EdDSA:<base64 seed>"
+//	JWT_ACTIVE_KEY="2024-06"
+//
+// The first key signs unless JWT_ACTIVE_KEY says otherwise. JWT_KEYS is required,
+// JWT_DEV_KEY=1 signs with a development key derived from SecretKey instead, which
+// anyone can compute from the source and must never be used in production.
+func LoadKeyring() error {
+	spec := os.Getenv("JWT_KEYS")
+	if spec == "" {
+		if os.Getenv("JWT_DEV_KEY") != "1" {
+			return errors.New("JWT_KEYS is not set, set it or JWT_DEV_KEY=1 to use the development signing key")
+		}
+		log.Println("JWT_DEV_KEY is set, using the development signing key")
+		secret := sha256.Sum256(SecretKey)
+		key, err := NewHMACKey("dev", secret[:])
+		if err != nil {
+			return err
+		}
+		Keys.Add(key, true)
+		return nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 {
+			return fmt.Errorf("invalid JWT_KEYS entry %q, expected kid:alg:base64", entry)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return fmt.Errorf("key %q: invalid base64: %v", parts[0], err)
+		}
+
+		var key *SigningKey
+		switch strings.ToUpper(parts[1]) {
+		case "HS256":
+			key, err = NewHMACKey(parts[0], material)
+		case "EDDSA", "ED25519":
+			key, err = NewEd25519Key(parts[0], material)
+		default:
+			err = fmt.Errorf("key %q: unsupported algorithm %q", parts[0], parts[1])
+		}
+		if err != nil {
+			return err
+		}
+		Keys.Add(key, false)
+	}
+
+	if active := os.Getenv("JWT_ACTIVE_KEY"); active != "" {
+		key, ok := Keys.Get(active)
+		if !ok {
+			return fmt.Errorf("JWT_ACTIVE_KEY %q is not in JWT_KEYS", active)
+		}
+		Keys.Add(key, true)
+	}
+	return nil
+}
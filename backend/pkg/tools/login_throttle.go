@@ -0,0 +1,179 @@
+package tools
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"social-network/pkg/models"
+)
+
+// a throttlePolicy locks a key after MaxFailures failed logins, for BaseLock
+// doubled on every further failure, up to MaxLock
+type throttlePolicy struct {
+	MaxFailures int
+	BaseLock    time.Duration
+	MaxLock     time.Duration
+}
+
+var (
+	accountThrottle = throttlePolicy{MaxFailures: 5, BaseLock: time.Minute, MaxLock: time.Hour}
+	// an address may try several accounts, but not forever
+	ipThrottle = throttlePolicy{MaxFailures: 20, BaseLock: time.Minute, MaxLock: time.Hour}
+)
+
+// failures older than this are forgotten
+const loginFailureWindow = time.Hour
+
+func (p throttlePolicy) lockDuration(failures int) time.Duration {
+	extra := failures - p.MaxFailures
+	if extra >= 16 {
+		return p.MaxLock
+	}
+	lock := p.BaseLock << extra
+	if lock > p.MaxLock {
+		return p.MaxLock
+	}
+	return lock
+}
+
+// LoginLockedError is returned while an account or an address is locked out
+type LoginLockedError struct {
+	Until time.Time
+}
+
+func (e *LoginLockedError) Error() string {
+	minutes := int(time.Until(e.Until).Minutes()) + 1
+	if minutes == 1 {
+		return "too many failed login attempts, try again in a minute"
+	}
+	return fmt.Sprintf("too many failed login attempts, try again in %d minutes", minutes)
+}
+
+// RetryAfter is the number of seconds to put in a Retry-After header
+func (e *LoginLockedError) RetryAfter() int {
+	return int(time.Until(e.Until).Seconds()) + 1
+}
+
+// accountThrottleKey throttles a known account by ID, so its email and nickname share one counter.
+// Unknown logins get a counter too, they must not be told apart from real accounts.
+func accountThrottleKey(userID int, login string) string {
+	if userID != 0 {
+		return "user:" + strconv.Itoa(userID)
+	}
+	return "login:" + strings.ToLower(strings.TrimSpace(login))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// CheckLoginAllowed returns a *LoginLockedError when the account or the address is locked out
+func CheckLoginAllowed(accountKey, ip string) error {
+	for _, key := range []string{ipThrottleKey(ip), accountKey} {
+		until, err := models.Db.GetLockedUntil(key)
+		if err != nil {
+			return err
+		}
+		if until != nil {
+			return &LoginLockedError{Until: *until}
+		}
+	}
+	return nil
+}
+
+// RecordLoginFailure counts a wrong password or code against the account and the address,
+// and locks them once they reach their limit
+func RecordLoginFailure(userID int, accountKey, ip string) {
+	if until, failures := countFailure(ipThrottleKey(ip), ipThrottle); until != nil {
+		log.Printf("login from %s locked until %s after %d failures\n", ip, until.Format(time.RFC3339), failures)
+	}
+
+	until, failures := countFailure(accountKey, accountThrottle)
+	if until == nil || userID == 0 {
+		return
+	}
+	// only the first lock of a streak is notified, the longer ones that follow are just recorded
+	notify := failures == accountThrottle.MaxFailures
+	if err := recordLockout(userID, ip, failures, *until, notify); err != nil {
+		log.Println("failed to record account lockout:", err)
+	}
+}
+
+// RecordLoginSuccess clears the failures of an account once a session is opened
+func RecordLoginSuccess(userID int) {
+	if err := models.Db.ClearLoginFailures(accountThrottleKey(userID, "")); err != nil {
+		log.Println("failed to clear login failures:", err)
+	}
+}
+
+// UnlockAccount lifts the lockout of an account before it expires
+func UnlockAccount(userID, adminID int) error {
+	if err := models.Db.ClearLoginFailures(accountThrottleKey(userID, "")); err != nil {
+		return err
+	}
+	return models.Db.MarkAccountUnlocked(userID, adminID)
+}
+
+// AccountLockedUntil returns until when an account is locked, nil when it isn't
+func AccountLockedUntil(userID int) (*time.Time, error) {
+	return models.Db.GetLockedUntil(accountThrottleKey(userID, ""))
+}
+
+// PurgeStaleLoginThrottles forgets counters with no recent failure
+func PurgeStaleLoginThrottles() {
+	if err := models.Db.DeleteStaleLoginThrottles(loginFailureWindow); err != nil {
+		log.Println("failed to purge login throttles:", err)
+	}
+}
+
+// countFailure returns the end of the lock it started, if any
+func countFailure(key string, policy throttlePolicy) (*time.Time, int) {
+	failures, err := models.Db.CountLoginFailure(key, loginFailureWindow)
+	if err != nil {
+		log.Println("failed to count login failure:", err)
+		return nil, 0
+	}
+	if failures < policy.MaxFailures {
+		return nil, failures
+	}
+
+	until := time.Now().Add(policy.lockDuration(failures))
+	if err := models.Db.LockLogin(key, until); err != nil {
+		log.Println("failed to lock login:", err)
+		return nil, failures
+	}
+	return &until, failures
+}
+
+// recordLockout keeps a trace of the lockout for the owner and tells them about it
+func recordLockout(userID int, ip string, failures int, until time.Time, notify bool) error {
+	lockoutID, err := models.Db.InsertAccountLockout(userID, ip, failures, until)
+	if err != nil || !notify {
+		return err
+	}
+
+	_, err = models.Db.InsertNotification(&models.Notification{
+		Type:       "account locked",
+		RelatedId:  lockoutID,
+		SenderId:   userID,
+		ReceiverId: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	user, err := models.Db.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	return Mail.Send(Email{
+		To:      user.Email,
+		Subject: "Your account was locked",
+		Body: fmt.Sprintf("Hi %s,\n\nAfter %d failed login attempts, the last one from %s, logging in to your account "+
+			"is blocked until %s UTC.\n\nIf it wasn't you, consider changing your password and enabling two-factor authentication.\n",
+			user.Firstname, failures, ip, until.UTC().Format("Jan 2, 2006 at 15:04")),
+	})
+}
@@ -0,0 +1,67 @@
+package tools
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockDuration(t *testing.T) {
+	policy := throttlePolicy{MaxFailures: 5, BaseLock: time.Minute, MaxLock: time.Hour}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{10, 32 * time.Minute},
+		{11, time.Hour},
+		{20, time.Hour},
+		{21, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := policy.lockDuration(tt.failures); got != tt.want {
+			t.Errorf("lockDuration(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestCountFailure(t *testing.T) {
+	openTestDB(t)
+
+	policy := throttlePolicy{MaxFailures: 3, BaseLock: time.Minute, MaxLock: time.Hour}
+	tests := []struct {
+		failures int
+		lock     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		before := time.Now()
+		until, failures := countFailure("user:1", policy)
+		if failures != tt.failures {
+			t.Fatalf("countFailure counted %d failures, want %d", failures, tt.failures)
+		}
+		if tt.lock == 0 {
+			if until != nil {
+				t.Errorf("failure %d locked until %s, want no lock", failures, until)
+			}
+			continue
+		}
+		if until == nil {
+			t.Fatalf("failure %d didn't lock", failures)
+		}
+		if got := until.Sub(before); got < tt.lock || got > tt.lock+time.Second {
+			t.Errorf("failure %d locked for %s, want %s", failures, got, tt.lock)
+		}
+	}
+
+	// keys are counted apart
+	if until, failures := countFailure("user:2", policy); until != nil || failures != 1 {
+		t.Errorf("other key: countFailure = %v, %d, want no lock and 1 failure", until, failures)
+	}
+}
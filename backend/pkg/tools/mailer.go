@@ -0,0 +1,119 @@
+package tools
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(email Email) error
+}
+
+// the mailer used for every email sent by the server
+var Mail Mailer = &LogMailer{}
+
+// the public address of the frontend, used to build links sent by email
+var AppURL = "http://localhost:3000"
+
+// SMTPMailer sends emails through an SMTP server, with PLAIN auth when a username is set
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(email Email) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{email.To}, formatEmail(m.From, email))
+}
+
+// LogMailer writes emails to the log, or appends them to a file when Path is set.
+// It is meant for local development and tests.
+type LogMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *LogMailer) Send(email Email) error {
+	message := formatEmail("no-reply@localhost", email)
+	if m.Path == "" {
+		log.Printf("email to %s:\n%s\n", email.To, message)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(m.Path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(message, []byte("\r\n")...))
+	return err
+}
+
+func formatEmail(from string, email Email) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", email.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", email.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(email.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// LoadMailer picks the mailer from the environment: SMTP when SMTP_HOST is set,
+// otherwise emails are appended to MAIL_FILE or written to the log
+func LoadMailer() error {
+	if url := os.Getenv("APP_URL"); url != "" {
+		AppURL = strings.TrimSuffix(url, "/")
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		Mail = &LogMailer{Path: os.Getenv("MAIL_FILE")}
+		return nil
+	}
+
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		return fmt.Errorf("MAIL_FROM is required with SMTP_HOST")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	Mail = &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package tools
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"social-network/pkg/models"
+)
+
+// MessageEditWindow is how long after sending a message its sender may still edit it
+var MessageEditWindow = 15 * time.Minute
+
+// LoadMessageSettings reads MESSAGE_EDIT_WINDOW, a duration such as "15m"
+func LoadMessageSettings() error {
+	value := os.Getenv("MESSAGE_EDIT_WINDOW")
+	if value == "" {
+		return nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		return fmt.Errorf("invalid MESSAGE_EDIT_WINDOW %q", value)
+	}
+	MessageEditWindow = window
+	return nil
+}
+
+// PurgeExpiredGroupMessages deletes the group messages older than their group's retention
+func PurgeExpiredGroupMessages() {
+	purged, err := models.Db.DeleteExpiredGroupMessages(time.Now())
+	if err != nil {
+		log.Println("failed to purge group messages:", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("purged %d expired group messages\n", purged)
+	}
+}
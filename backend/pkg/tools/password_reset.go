@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"social-network/pkg/models"
+)
+
+const PasswordResetLifetime = time.Hour
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+var (
+	// reset emails an address can be sent, and reset requests a client can make, before a pause.
+	// Unknown addresses count too, so they can't be told apart.
+	resetEmailThrottle = throttlePolicy{MaxFailures: 3, BaseLock: 15 * time.Minute, MaxLock: time.Hour}
+	resetIPThrottle    = throttlePolicy{MaxFailures: 10, BaseLock: 15 * time.Minute, MaxLock: time.Hour}
+)
+
+// AllowPasswordReset counts a reset request for an email address from a client, it returns
+// until when they are refused when either of them asked too often
+func AllowPasswordReset(email, ip string) (*time.Time, error) {
+	emailKey := "reset:" + strings.ToLower(strings.TrimSpace(email))
+	ipKey := "reset-ip:" + ip
+	for _, key := range []string{ipKey, emailKey} {
+		until, err := models.Db.GetLockedUntil(key)
+		if err != nil || until != nil {
+			return until, err
+		}
+	}
+	countFailure(ipKey, resetIPThrottle)
+	countFailure(emailKey, resetEmailThrottle)
+	return nil, nil
+}
+
+// RequestPasswordReset emails a reset link to the owner of an email address.
+// Unknown addresses are ignored silently so they can't be probed.
+func RequestPasswordReset(email string) error {
+	user, err := models.Db.GetUserByLogin(email)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil
+		}
+		return err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if err := models.Db.InsertPasswordReset(user.ID, hashToken(token), time.Now().Add(PasswordResetLifetime)); err != nil {
+		return err
+	}
+
+	link := AppURL + "/reset-password?token=" + url.QueryEscape(token)
+	return Mail.Send(Email{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+			"Open this link within %d minutes to choose a new one:\n\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n",
+			user.Firstname, int(PasswordResetLifetime.Minutes()), link),
+	})
+}
+
+// ResetPassword consumes a reset token and sets a new password, every session and personal
+// access token of the user is revoked and their session IDs returned so live sockets can be closed
+func ResetPassword(token, password string) ([]string, error) {
+	reset, err := models.Db.GetPasswordReset(hashToken(token))
+	if err != nil {
+		if err.Error() == "password reset not found" {
+			return nil, ErrInvalidResetToken
+		}
+		return nil, err
+	}
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		return nil, ErrInvalidResetToken
+	}
+
+	consumed, err := models.Db.MarkPasswordResetUsed(reset.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, ErrInvalidResetToken
+	}
+
+	if err := models.Db.UpdatePasswordHash(reset.UserID, password); err != nil {
+		return nil, err
+	}
+	revoked, err := models.Db.DeleteUserTokensExcept(reset.UserID, "")
+	if err != nil {
+		return nil, err
+	}
+	apiTokens, err := models.Db.DeleteUserAPITokens(reset.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range apiTokens {
+		revoked = append(revoked, APITokenSessionID(id))
+	}
+	return revoked, nil
+}
+
+// PurgeExpiredPasswordResets removes reset tokens nobody used in time
+func PurgeExpiredPasswordResets() {
+	if err := models.Db.DeleteExpiredPasswordResets(); err != nil {
+		log.Println("failed to purge expired password resets:", err)
+	}
+}
@@ -0,0 +1,281 @@
+package tools
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"social-network/pkg/models"
+)
+
+const (
+	// codes follow RFC 6238 defaults, the ones every authenticator app supports
+	totpPeriod = 30
+	totpDigits = 6
+	// codes from the previous and next periods are accepted to absorb clock drift
+	totpSkew = 1
+
+	totpIssuer = "Social Network"
+
+	RecoveryCodeCount = 10
+
+	LoginChallengeLifetime = 5 * time.Minute
+	// wrong codes allowed on one challenge before the login has to start over
+	loginChallengeAttempts = 5
+)
+
+var (
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrInvalidTwoFactorCode = errors.New("invalid authentication code")
+	ErrInvalidChallenge     = errors.New("invalid or expired login challenge, please log in again")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorSetup is what an authenticator app needs to enroll
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// BeginTwoFactorSetup generates a new pending secret for a user. It only takes
+// effect once ConfirmTwoFactor receives a first code computed from it.
+func BeginTwoFactorSetup(user *models.User) (*TwoFactorSetup, error) {
+	current, err := models.Db.GetTwoFactor(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	secret := totpEncoding.EncodeToString(buf)
+	if err := models.Db.SetPendingTOTPSecret(user.ID, secret); err != nil {
+		return nil, err
+	}
+
+	label := url.PathEscape(totpIssuer + ":" + user.Email)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+
+	return &TwoFactorSetup{
+		Secret: secret,
+		URI:    "otpauth://totp/" + label + "?" + params.Encode(),
+	}, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication when the code matches the
+// pending secret, and returns the recovery codes to show the user once
+func ConfirmTwoFactor(userID int, code string) ([]string, error) {
+	current, err := models.Db.GetTwoFactor(userID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if current.Secret == "" {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	ok, err := checkTOTP(userID, current.Secret, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if err := models.Db.EnableTOTP(userID); err != nil {
+		return nil, err
+	}
+	return RegenerateRecoveryCodes(userID)
+}
+
+// DisableTwoFactor turns two-factor authentication off, the caller has already
+// checked the user's password or a code
+func DisableTwoFactor(userID int) error {
+	return models.Db.DisableTOTP(userID)
+}
+
+// IsTwoFactorEnabled reports whether logging in as a user needs a second step
+func IsTwoFactorEnabled(userID int) (bool, error) {
+	current, err := models.Db.GetTwoFactor(userID)
+	if err != nil {
+		return false, err
+	}
+	return current.Enabled, nil
+}
+
+// VerifyAccountSecondFactor checks a code of a signed in user like VerifySecondFactor, but a
+// locked out account is refused and wrong codes count towards its lockout like at login
+func VerifyAccountSecondFactor(userID int, code, ip string) (bool, error) {
+	accountKey := accountThrottleKey(userID, "")
+	if err := CheckLoginAllowed(accountKey, ip); err != nil {
+		return false, err
+	}
+	ok, err := VerifySecondFactor(userID, code)
+	if err != nil || ok {
+		return ok, err
+	}
+	RecordLoginFailure(userID, accountKey, ip)
+	return false, nil
+}
+
+// VerifySecondFactor accepts either a current TOTP code or an unused recovery code
+func VerifySecondFactor(userID int, code string) (bool, error) {
+	current, err := models.Db.GetTwoFactor(userID)
+	if err != nil {
+		return false, err
+	}
+	if !current.Enabled {
+		return false, ErrTwoFactorNotEnabled
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) == totpDigits {
+		return checkTOTP(userID, current.Secret, code)
+	}
+	return models.Db.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)))
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user, only their hashes are stored
+func RegenerateRecoveryCodes(userID int) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	if err := models.Db.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
+
+// StartLoginChallenge is issued instead of a session when the password of a user with
+// two-factor authentication is correct, it is traded for a session with a valid code
+func StartLoginChallenge(userID int) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	err = models.Db.InsertLoginChallenge(userID, hashToken(token), time.Now().Add(LoginChallengeLifetime))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CompleteLoginChallenge checks the code sent for a challenge and returns the user
+// to open a session for. A challenge is consumed by success or too many wrong codes,
+// and wrong codes count towards the lockout of the account like wrong passwords.
+func CompleteLoginChallenge(token, code, ip string) (int, error) {
+	challenge, err := models.Db.GetLoginChallenge(hashToken(token))
+	if err != nil {
+		if err.Error() == "login challenge not found" {
+			return 0, ErrInvalidChallenge
+		}
+		return 0, err
+	}
+	if time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= loginChallengeAttempts {
+		models.Db.DeleteLoginChallenge(challenge.ID)
+		return 0, ErrInvalidChallenge
+	}
+
+	accountKey := accountThrottleKey(challenge.UserID, "")
+	if err := CheckLoginAllowed(accountKey, ip); err != nil {
+		return 0, err
+	}
+
+	ok, err := VerifySecondFactor(challenge.UserID, code)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		RecordLoginFailure(challenge.UserID, accountKey, ip)
+		if err := models.Db.CountLoginChallengeAttempt(challenge.ID); err != nil {
+			return 0, err
+		}
+		return 0, ErrInvalidTwoFactorCode
+	}
+
+	consumed, err := models.Db.DeleteLoginChallenge(challenge.ID)
+	if err != nil {
+		return 0, err
+	}
+	if !consumed {
+		return 0, ErrInvalidChallenge
+	}
+	return challenge.UserID, nil
+}
+
+// PurgeExpiredLoginChallenges removes challenges of logins that were never completed
+func PurgeExpiredLoginChallenges() {
+	if err := models.Db.DeleteExpiredLoginChallenges(); err != nil {
+		log.Println("failed to purge expired login challenges:", err)
+	}
+}
+
+// checkTOTP validates a code against a secret, and records its time step so it can't be used twice
+func checkTOTP(userID int, secret, code string) (bool, error) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return models.Db.UseTOTPStep(userID, step)
+		}
+	}
+	return false, nil
+}
+
+// totpCode computes the code of a time step as described in RFC 4226 and RFC 6238
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B, SHA1 key, cut down to the 6 digits the codes use
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	openTestDB(t)
+	userID := insertTestUser(t, "totp@example.com")
+
+	key := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Now().Unix() / totpPeriod
+
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"wrong code", "000000x", false},
+		{"previous period", totpCode(key, now-totpSkew), true},
+		{"current period", totpCode(key, now), true},
+		{"replayed code", totpCode(key, now), false},
+		{"older period after a newer one", totpCode(key, now-totpSkew), false},
+		{"outside the skew", totpCode(key, now+totpSkew+1), false},
+	}
+	for _, tt := range tests {
+		got, err := checkTOTP(userID, secret, tt.code)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: checkTOTP = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package tools
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"regexp"
 	"strings"
@@ -26,7 +27,7 @@ func ValidateRegisterForum(firstName, lastName, email, password, date, nickName,
 
 	if !IsValidPassword(password) {
 		// fmt.Println("password :", password)
-		return http.StatusBadRequest, errors.New("password must be at least 8 characters and include at least one uppercase letter, one lowercase letter, and one number")
+		return http.StatusBadRequest, ErrInvalidPassword
 	}
 
 	if !IsValidDateOfBirth(date) {
@@ -50,6 +51,8 @@ func ValidateRegisterForum(firstName, lastName, email, password, date, nickName,
 	return 0, nil
 }
 
+var ErrInvalidPassword = errors.New("password must be at least 8 characters and include at least one uppercase letter, one lowercase letter, and one number")
+
 // Validate password (at least 8 characters, 1 uppercase, 1 lowercase, 1 number)
 func IsValidPassword(password string) bool {
 	if len(password) < 8 {
@@ -140,22 +143,65 @@ func ValidatePostImage(fileExt string, fileSize int64) error {
 	return nil
 }
 
-func CheckLoginInfto(login string, password string) (*models.User, int, error) {
+// CheckAccountPassword checks the password of a signed in user, a locked out account is
+// refused and wrong passwords count towards its lockout like at login
+func CheckAccountPassword(user *models.User, password, ip string) (bool, error) {
+	accountKey := accountThrottleKey(user.ID, "")
+	if err := CheckLoginAllowed(accountKey, ip); err != nil {
+		return false, err
+	}
+	if ok, _ := user.ComparePassword(password); !ok {
+		RecordLoginFailure(user.ID, accountKey, ip)
+		return false, nil
+	}
+	return true, nil
+}
+
+func CheckLoginInfto(login string, password string, ip string) (*models.User, int, error) {
 	var user *models.User
 	if login == "" || password == "" {
 		return user, http.StatusBadRequest, errors.New("please fill all the fields")
 	}
 
+	// the same message for an unknown login and a wrong password, so emails can't be probed
+	invalidLogin := errors.New("invalid login or password")
+
 	user, err := models.Db.GetUserByLogin(login)
-	if err != nil {
-		if err.Error() == "user not found" {
-			return user, http.StatusBadRequest, err
+	if err != nil && err.Error() != "user not found" {
+		return nil, http.StatusInternalServerError, err
+	}
+	userID := 0
+	if user != nil {
+		userID = user.ID
+	}
+
+	// locked out logins are refused before the password is even looked at
+	accountKey := accountThrottleKey(userID, login)
+	if err := CheckLoginAllowed(accountKey, ip); err != nil {
+		var locked *LoginLockedError
+		if errors.As(err, &locked) {
+			return nil, http.StatusTooManyRequests, err
 		}
-		return user, http.StatusInternalServerError, err
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if user == nil {
+		models.DummyPasswordCheck(password)
+		RecordLoginFailure(0, accountKey, ip)
+		return nil, http.StatusBadRequest, invalidLogin
 	}
 
-	if !user.ComparePassword(password) {
-		return user, http.StatusBadRequest, errors.New("incorrect password")
+	ok, needsRehash := user.ComparePassword(password)
+	if !ok {
+		RecordLoginFailure(user.ID, accountKey, ip)
+		return nil, http.StatusBadRequest, invalidLogin
+	}
+
+	// upgrade hashes made with an older algorithm or cost while we have the plain password
+	if needsRehash {
+		if err := models.Db.UpdatePasswordHash(user.ID, password); err != nil {
+			fmt.Println("failed to upgrade password hash:", err)
+		}
 	}
 
 	return user, http.StatusOK, nil
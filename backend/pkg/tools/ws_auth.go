@@ -0,0 +1,136 @@
+package tools
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"social-network/pkg/models"
+)
+
+// WSTicketLifetime is how long a client has to open its socket after asking for a ticket
+const WSTicketLifetime = 30 * time.Second
+
+var ErrSocketUnauthorized = errors.New("invalid or expired credentials")
+
+// SocketIdentity is who a WebSocket belongs to once authenticated
+type SocketIdentity struct {
+	UserID int
+	// SessionID closes the socket when the session or api token is revoked
+	SessionID string
+	// Scopes is nil for sessions, which may do anything
+	Scopes []string
+}
+
+// Allows reports whether the socket may do what a scope grants
+func (s *SocketIdentity) Allows(scope string) bool {
+	if s.Scopes == nil {
+		return true
+	}
+	for _, granted := range s.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APITokenSessionID is the session key of sockets opened with a personal access token
+func APITokenSessionID(apiTokenID int) string {
+	return "api:" + strconv.Itoa(apiTokenID)
+}
+
+// IssueWSTicket creates a single-use ticket for the session or api token of an authenticated request
+func IssueWSTicket(userID int, sessionID string, apiTokenID int) (string, error) {
+	ticket, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	err = models.Db.InsertWSTicket(hashToken(ticket), models.WSTicket{
+		UserID:     userID,
+		SessionID:  sessionID,
+		APITokenID: apiTokenID,
+		ExpiresAt:  time.Now().Add(WSTicketLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// AuthenticateSocket checks a ticket, or else a session or personal access token
+func AuthenticateSocket(token, ticket string) (*SocketIdentity, error) {
+	if ticket != "" {
+		return redeemWSTicket(ticket)
+	}
+
+	token = strings.TrimPrefix(token, "Bearer ")
+	if token == "" {
+		return nil, ErrSocketUnauthorized
+	}
+	if IsAPIToken(token) {
+		apiToken, err := CheckAPIToken(token)
+		if err != nil {
+			return nil, ErrSocketUnauthorized
+		}
+		return apiTokenIdentity(apiToken)
+	}
+
+	claims, err := CheckTokenClaims("Bearer " + token)
+	if err != nil {
+		return nil, ErrSocketUnauthorized
+	}
+	return &SocketIdentity{UserID: claims.UserID, SessionID: claims.TokenID}, nil
+}
+
+// redeemWSTicket consumes a ticket, the session or api token it came from must still be valid
+func redeemWSTicket(ticket string) (*SocketIdentity, error) {
+	stored, err := models.Db.UseWSTicket(hashToken(ticket))
+	if err != nil {
+		if err.Error() == "ws ticket not found" {
+			return nil, ErrSocketUnauthorized
+		}
+		return nil, err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrSocketUnauthorized
+	}
+
+	if stored.APITokenID != 0 {
+		apiToken, err := models.Db.GetAPITokenByID(stored.APITokenID)
+		if err != nil || apiToken.UserID != stored.UserID {
+			return nil, ErrSocketUnauthorized
+		}
+		if apiToken.ExpiresAt != nil && time.Now().After(*apiToken.ExpiresAt) {
+			return nil, ErrSocketUnauthorized
+		}
+		return apiTokenIdentity(apiToken)
+	}
+
+	session, err := models.Db.GetToken(stored.SessionID)
+	if err != nil || session.UserID != stored.UserID || time.Now().After(session.ExpiresAt) {
+		return nil, ErrSocketUnauthorized
+	}
+	return &SocketIdentity{UserID: stored.UserID, SessionID: stored.SessionID}, nil
+}
+
+// api tokens need messages:read to receive messages on a socket
+func apiTokenIdentity(apiToken *models.APIToken) (*SocketIdentity, error) {
+	if !apiToken.HasScope("messages:read") {
+		return nil, ErrSocketUnauthorized
+	}
+	return &SocketIdentity{
+		UserID:    apiToken.UserID,
+		SessionID: APITokenSessionID(apiToken.ID),
+		Scopes:    apiToken.Scopes,
+	}, nil
+}
+
+// PurgeExpiredWSTickets removes tickets that were never used
+func PurgeExpiredWSTickets() {
+	if err := models.Db.DeleteExpiredWSTickets(); err != nil {
+		log.Println("failed to purge ws tickets:", err)
+	}
+}
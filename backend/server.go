@@ -5,16 +5,21 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"social-network/pkg/db/sqlite"
 	"social-network/pkg/handlers"
 	"social-network/pkg/models"
+	"social-network/pkg/tools"
 )
 
 func init() {
 	db := sqlite.CreateAllTables()
 	models.Db = models.InitializeDb(db)
+	if err := models.Db.InitMessageSearch(); err != nil {
+		fmt.Println("full-text search unavailable, messages are searched with LIKE:", err)
+	}
 }
 
 func main() {
@@ -26,10 +31,61 @@ func main() {
 	}
 	handlers.InitDB(dbConn)
 
+	if err := tools.LoadKeyring(); err != nil {
+		panic(err)
+	}
+	if err := tools.LoadPasswordHasher(); err != nil {
+		panic(err)
+	}
+	if err := tools.LoadMailer(); err != nil {
+		panic(err)
+	}
+	if err := tools.LoadVerificationSettings(); err != nil {
+		panic(err)
+	}
+	if err := tools.LoadMessageSettings(); err != nil {
+		panic(err)
+	}
+	if err := tools.LoadAttachmentSettings(); err != nil {
+		panic(err)
+	}
+	if err := tools.LoadProxySettings(); err != nil {
+		panic(err)
+	}
+
+	// purge expired sessions from the token table
+	tools.RunEvery(time.Hour, tools.PurgeExpiredTokens)
+	tools.RunEvery(time.Hour, tools.PurgeExpiredPasswordResets)
+	tools.RunEvery(time.Hour, tools.PurgeUnverifiedAccounts)
+	tools.RunEvery(time.Hour, tools.PurgeExpiredLoginChallenges)
+	tools.RunEvery(time.Hour, tools.PurgeStaleLoginThrottles)
+	tools.RunEvery(time.Hour, tools.PurgeExpiredWSTickets)
+	tools.RunEvery(time.Hour, tools.PurgeExpiredGroupMessages)
+	tools.RunEvery(time.Hour, tools.PurgeOrphanAttachments)
+
 	// 🛠 APIs
 	http.HandleFunc("/api/register", handlers.HandleCORS(handlers.Register))
 	http.HandleFunc("/api/login", handlers.HandleCORS(handlers.Login))
+	http.HandleFunc("/api/login/2fa", handlers.HandleCORS(handlers.LoginTwoFactor))
+	http.HandleFunc("/api/token/refresh", handlers.HandleCORS(handlers.RefreshToken))
 	http.HandleFunc("/api/logout", handlers.HandleCORS(handlers.TokenMiddleware(handlers.Logout)))
+	http.HandleFunc("/api/sessions", handlers.HandleCORS(handlers.TokenMiddleware(handlers.SessionsHandler)))
+	http.HandleFunc("/api/sessions/{id}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.SessionHandler)))
+	http.HandleFunc("/api/tokens", handlers.HandleCORS(handlers.TokenMiddleware(handlers.APITokensHandler)))
+	http.HandleFunc("/api/tokens/{id}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.APITokenHandler)))
+	http.HandleFunc("/api/account/password", handlers.HandleCORS(handlers.TokenMiddleware(handlers.ChangePassword)))
+	http.HandleFunc("/api/account/2fa", handlers.HandleCORS(handlers.TokenMiddleware(handlers.TwoFactorHandler)))
+	http.HandleFunc("/api/account/2fa/setup", handlers.HandleCORS(handlers.TokenMiddleware(handlers.SetupTwoFactor)))
+	http.HandleFunc("/api/account/2fa/confirm", handlers.HandleCORS(handlers.TokenMiddleware(handlers.ConfirmTwoFactor)))
+	http.HandleFunc("/api/account/2fa/disable", handlers.HandleCORS(handlers.TokenMiddleware(handlers.DisableTwoFactor)))
+	http.HandleFunc("/api/account/2fa/recovery-codes", handlers.HandleCORS(handlers.TokenMiddleware(handlers.RegenerateRecoveryCodes)))
+	http.HandleFunc("/api/account/lockouts", handlers.HandleCORS(handlers.TokenMiddleware(handlers.LockoutsHandler)))
+	http.HandleFunc("/api/admin/users/{id}/unlock", handlers.HandleCORS(handlers.TokenMiddleware(handlers.AdminMiddleware(handlers.UnlockUserHandler))))
+	http.HandleFunc("/api/admin/ws/metrics", handlers.HandleCORS(handlers.TokenMiddleware(handlers.AdminMiddleware(handlers.GatewayMetricsHandler))))
+	http.HandleFunc("/api/account/verify", handlers.HandleCORS(handlers.VerifyEmail))
+	http.HandleFunc("/api/account/verify/resend", handlers.HandleCORS(handlers.TokenMiddleware(handlers.ResendVerification)))
+	http.HandleFunc("/api/password/forgot", handlers.HandleCORS(handlers.ForgotPassword))
+	http.HandleFunc("/api/password/reset", handlers.HandleCORS(handlers.ResetPassword))
 	http.HandleFunc("/api/user", handlers.HandleCORS(handlers.TokenMiddleware(handlers.CurrentUserHandler)))
 	http.HandleFunc("/api/users", handlers.HandleCORS(handlers.TokenMiddleware(handlers.GetAllUsersHandler)))
 	http.HandleFunc("/api/profile", handlers.HandleCORS(handlers.TokenMiddleware(handlers.ProfileHandler)))
@@ -40,23 +96,43 @@ func main() {
 	http.HandleFunc("/api/follow/{userID}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.FollowUser)))
 	http.HandleFunc("/api/followResponse", handlers.HandleCORS(handlers.TokenMiddleware(handlers.FollowResponse)))
 
-	http.HandleFunc("/api/posts", handlers.HandleCORS(handlers.TokenMiddleware(handlers.PostsHandler)))
-	http.Handle("/api/posts/", handlers.HandleCORSHandler(handlers.TokenMiddlewareHandler(handlers.PostRouter())))
+	http.HandleFunc("/api/posts", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.PostsHandler))))
+	http.Handle("/api/posts/", handlers.HandleCORSHandler(handlers.TokenMiddlewareHandler(handlers.VerifiedMiddlewareHandler(handlers.PostRouter()))))
 	http.HandleFunc("/api/upload/avatar", handlers.HandleCORS(handlers.TokenMiddleware(handlers.UploadAvatar)))
-	http.HandleFunc("/api/upload/post-image", handlers.HandleCORS(handlers.TokenMiddleware(handlers.UploadPostImage)))
+	http.HandleFunc("/api/upload/post-image", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.UploadPostImage))))
 
-	// 🛠 WebSocket Hub
-	hub := handlers.NewHub()
-	go hub.Run()
+	// 🛠 WebSocket gateway
+	gateway := handlers.NewGateway()
+	handlers.InitGateway(gateway)
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		handlers.HandleWebSocket(hub, w, r)
+		handlers.HandleWebSocket(gateway, w, r)
 	})
-	http.HandleFunc("/ws/group", handlers.GroupChatWebSocket)
+	http.HandleFunc("/api/ws/ticket", handlers.HandleCORS(handlers.TokenMiddleware(handlers.WSTicketHandler)))
 
 	http.HandleFunc("/api/messages", handlers.HandleCORS(handlers.TokenMiddleware(handlers.GetMessages)))
+	http.HandleFunc("/api/presence", handlers.HandleCORS(handlers.TokenMiddleware(handlers.PresenceHandler)))
+	http.HandleFunc("/api/account/presence", handlers.HandleCORS(handlers.TokenMiddleware(handlers.PresenceSettingsHandler)))
+	http.HandleFunc("/api/chats", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.ChatsHandler))))
+	http.HandleFunc("/api/chats/{id}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.ChatHandler)))
+	http.HandleFunc("/api/chats/{id}/messages", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.ChatMessagesHandler))))
+	http.HandleFunc("/api/chats/unread", handlers.HandleCORS(handlers.TokenMiddleware(handlers.UnreadMessagesHandler)))
+	http.HandleFunc("/api/chats/{id}/read", handlers.HandleCORS(handlers.TokenMiddleware(handlers.ChatReadHandler)))
+	http.HandleFunc("/api/chats/{id}/messages/{messageID}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.ChatMessageHandler))))
+	http.HandleFunc("/api/chats/{id}/messages/{messageID}/edits", handlers.HandleCORS(handlers.TokenMiddleware(handlers.ChatMessageEditsHandler)))
+	http.HandleFunc("/api/chats/{id}/messages/{messageID}/reactions/{emoji}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.MessageReactionHandler))))
+	http.HandleFunc("/api/attachments", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.UploadAttachmentHandler))))
+	http.HandleFunc("/api/attachments/{id}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.AttachmentHandler)))
+	http.HandleFunc("/api/attachments/{id}/thumbnail", handlers.HandleCORS(handlers.TokenMiddleware(handlers.AttachmentThumbnailHandler)))
+	http.HandleFunc("/api/chats/requests", handlers.HandleCORS(handlers.TokenMiddleware(handlers.MessageRequestsHandler)))
+	http.HandleFunc("/api/chats/{id}/accept", handlers.HandleCORS(handlers.TokenMiddleware(handlers.AcceptMessageRequestHandler)))
+	http.HandleFunc("/api/chats/{id}/decline", handlers.HandleCORS(handlers.TokenMiddleware(handlers.DeclineMessageRequestHandler)))
+	http.HandleFunc("/api/blocks", handlers.HandleCORS(handlers.TokenMiddleware(handlers.BlocksHandler)))
+	http.HandleFunc("/api/blocks/{userID}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.BlockHandler)))
+	http.HandleFunc("/api/search/messages", handlers.HandleCORS(handlers.TokenMiddleware(handlers.SearchMessagesHandler)))
+	http.HandleFunc("/api/chats/{id}/members", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.ChatMembersHandler))))
 
 	// Groups
-	http.HandleFunc("/api/groups", handlers.HandleCORS(handlers.TokenMiddleware(handlers.GroupsHandler)))
+	http.HandleFunc("/api/groups", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.GroupsHandler))))
 	http.HandleFunc("/api/groups/invite", handlers.HandleCORS(handlers.TokenMiddleware(handlers.GroupInviteHandler)))
 	http.HandleFunc("/api/groups/invitation/response", handlers.HandleCORS(handlers.TokenMiddleware(handlers.GroupInvitationResponseHandler)))
 	http.HandleFunc("/api/groups/request", handlers.HandleCORS(handlers.TokenMiddleware(handlers.GroupRequestHandler)))
@@ -72,16 +148,21 @@ func main() {
 	})
 
 	http.HandleFunc("/api/notifications", handlers.HandleCORS(handlers.TokenMiddleware(handlers.NotificationsHandler)))
-	http.Handle("/api/notifications/read", handlers.HandleCORS(handlers.TokenMiddleware(handlers.MarkNotificationAsReadHandler)))
+	for _, action := range []string{"read", "unread", "archive", "unarchive", "delete"} {
+		http.HandleFunc("/api/notifications/"+action, handlers.HandleCORS(handlers.TokenMiddleware(handlers.NotificationActionHandler(action))))
+	}
+	http.HandleFunc("/api/notifications/{id}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.NotificationHandler)))
 
-	http.HandleFunc("/api/groups/chat", handlers.HandleCORS(handlers.TokenMiddleware(handlers.PostGroupMessage)))
+	http.HandleFunc("/api/groups/chat", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.PostGroupMessage))))
 	http.HandleFunc("/api/groups/messages", handlers.HandleCORS(handlers.TokenMiddleware(handlers.GetGroupMessages)))
+	http.HandleFunc("/api/groups/{id}/messages/{messageID}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.GroupMessageHandler))))
+	http.HandleFunc("/api/groups/{id}/messages/{messageID}/edits", handlers.HandleCORS(handlers.TokenMiddleware(handlers.GroupMessageEditsHandler)))
+	http.HandleFunc("/api/groups/{id}/messages/{messageID}/reactions/{emoji}", handlers.HandleCORS(handlers.TokenMiddleware(handlers.VerifiedMiddleware(handlers.GroupMessageReactionHandler))))
+	http.HandleFunc("/api/groups/{id}/retention", handlers.HandleCORS(handlers.TokenMiddleware(handlers.GroupRetentionHandler)))
 
 	http.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads"))))
 
 	http.HandleFunc("/", handlers.HomeHandler)
 
-	handlers.InitGroupChatHub()
-
 	http.ListenAndServe(":8080", nil)
 }
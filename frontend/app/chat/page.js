@@ -8,6 +8,7 @@ const ChatPage = () => {
   const [messages, setMessages] = useState([]);
   const [input, setInput] = useState("");
   const ws = useRef(null);
+  const chatId = useRef(null);
 
   const searchParams = useSearchParams();
   const otherUserId = searchParams.get("user");
@@ -29,9 +30,44 @@ console.log(userId, "user" ,otherUserId, "otherUserId");
   useEffect(() => {
     if (!userId) return; // Wait for userId before connecting
 
-    ws.current = new WebSocket(`ws://localhost:8080/ws?userid=${userId}`);
+    const token = localStorage.getItem("token");
+
+    // opening the 1:1 chat gives its id, used to pick its messages out of the "dm" topic
+    fetch("http://localhost:8080/api/chats", {
+      method: "POST",
+      headers: { Authorization: `Bearer ${token}`, "Content-Type": "application/json" },
+      body: JSON.stringify({ user_ids: [Number(otherUserId)] }),
+    })
+      .then((res) => res.json())
+      .then((chat) => {
+        chatId.current = chat.id;
+        return fetch(`http://localhost:8080/api/chats/${chat.id}/messages`, {
+          headers: { Authorization: `Bearer ${token}` },
+        });
+      })
+      .then((res) => res.json())
+      .then((page) => {
+        fetch(`http://localhost:8080/api/chats/${chatId.current}/read`, {
+          method: "POST",
+          headers: { Authorization: `Bearer ${token}` },
+        });
+        setMessages(
+          page.messages.map((m) => ({
+            id: m.id,
+            text: m.deleted ? "This message was deleted" : m.content,
+            sender: m.sender_id,
+            time: m.created_at,
+            isOwn: String(m.sender_id) === userId,
+          }))
+        );
+      })
+      .catch((err) => console.error("Failed to load the conversation:", err));
+
+    ws.current = new WebSocket("ws://localhost:8080/ws");
 
     ws.current.onopen = () => {
+      // the first frame binds the socket to our session
+      ws.current.send(JSON.stringify({ type: "auth", token }));
       console.log("WebSocket connected");
     };
 
@@ -40,18 +76,50 @@ console.log(userId, "user" ,otherUserId, "otherUserId");
         const msg = JSON.parse(event.data);
         console.log("Received:", msg);
 
-        if (msg.type === "messageuser" && msg.sender && msg.content) {
-          setMessages((prevMessages) => [
+        if (msg.type === "logout") {
+          localStorage.removeItem("token");
+          localStorage.removeItem("refreshToken");
+          localStorage.removeItem("userId");
+          localStorage.removeItem("isLoggedIn");
+          window.location.href = "/login";
+          return;
+        }
+
+        if (msg.type === "authenticated") {
+          ws.current.send(JSON.stringify({ type: "subscribe", topic: "dm" }));
+          return;
+        }
+
+        // our own messages come back too, so every device shows them
+        const data = msg.data;
+        if (msg.type === "event" && msg.topic === "dm" && msg.event === "message" && data) {
+          if (data.chat_id !== chatId.current) return;
+          // a message replayed on reconnect may already be shown
+          setMessages((prevMessages) => prevMessages.some((m) => m.id === data.id) ? prevMessages : [
             ...prevMessages,
             {
-              id: prevMessages.length + 1,
-              text: msg.content,
-              sender: msg.sender,
-              time: msg.timestamp,
-              isOwn: msg.sender === userId,
+              id: data.id,
+              text: data.content,
+              sender: data.sender_id,
+              time: data.created_at,
+              isOwn: String(data.sender_id) === userId,
             },
           ]);
         }
+
+        // edits, reactions and deletions replace the message we already show
+        if (msg.type === "event" && msg.topic === "dm" && msg.event === "message_updated" && data) {
+          if (data.chat_id !== chatId.current) return;
+          setMessages((prevMessages) =>
+            prevMessages.map((m) =>
+              m.id === data.id ? { ...m, text: data.deleted ? "This message was deleted" : data.content } : m
+            )
+          );
+        }
+
+        if (msg.type === "event" && msg.topic === "dm" && msg.event === "message_hidden" && data) {
+          setMessages((prevMessages) => prevMessages.filter((m) => m.id !== data.message_id));
+        }
       } catch (err) {
         console.error("Failed to parse WebSocket message:", err);
       }
@@ -70,7 +138,7 @@ console.log(userId, "user" ,otherUserId, "otherUserId");
         ws.current.close();
       }
     };
-  }, [userId]);
+  }, [userId, otherUserId]);
 
   const sendMessage = () => {
     console.log(otherUserId, "herrerre");
@@ -80,29 +148,13 @@ console.log(userId, "user" ,otherUserId, "otherUserId");
       return;
     }
     if (input.trim() && ws.current && ws.current.readyState === WebSocket.OPEN) {
-      const msg = {
-        type: "messageuser",
-        sender: userId,
-        receiver: [otherUserId],
-        content: input,
-        groupid: 0,
-        notificationid: 0,
-        offset: 0,
-        timestamp: new Date().toISOString(),
-      };
-
-      ws.current.send(JSON.stringify(msg));
-
-      setMessages((prev) => [
-        ...prev,
-        {
-          id: prev.length + 1,
-          text: input,
-          sender: "You",
-          time: new Date().toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" }),
-          isOwn: true,
-        },
-      ]);
+      ws.current.send(
+        JSON.stringify({
+          type: "send",
+          topic: `dm:${otherUserId}`,
+          data: { content: input, client_id: crypto.randomUUID() },
+        })
+      );
 
       setInput("");
     }
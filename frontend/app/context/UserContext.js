@@ -4,11 +4,61 @@ import { useRouter } from 'next/navigation';
 
 const UserContext = createContext(null);
 
+// Renew the access token transparently when the API reports it has expired
+function installTokenRefresh() {
+  if (typeof window === "undefined" || window.__tokenRefreshInstalled) return;
+  window.__tokenRefreshInstalled = true;
+
+  const originalFetch = window.fetch.bind(window);
+  let refreshing = null;
+
+  const refresh = () => {
+    if (!refreshing) {
+      refreshing = originalFetch("http://localhost:8080/api/token/refresh", {
+        method: "POST",
+        credentials: "include",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ refresh_token: localStorage.getItem("refreshToken") }),
+      })
+        .then(async (response) => {
+          if (!response.ok) throw new Error("Token refresh failed");
+          const data = await response.json();
+          localStorage.setItem("token", data.token);
+          localStorage.setItem("refreshToken", data.refresh_token);
+          return data.token;
+        })
+        .finally(() => {
+          refreshing = null;
+        });
+    }
+    return refreshing;
+  };
+
+  window.fetch = async (input, init = {}) => {
+    const response = await originalFetch(input, init);
+    if (response.status !== 401 || !init.headers) return response;
+
+    const body = await response.clone().json().catch(() => null);
+    if (!body || body.code !== "token_expired") return response;
+
+    try {
+      const token = await refresh();
+      const headers = new Headers(init.headers);
+      headers.set("Authorization", `Bearer ${token}`);
+      return originalFetch(input, { ...init, headers });
+    } catch (error) {
+      return response;
+    }
+  };
+}
+
 export function UserProvider({ children }) {
   const [user, setUser] = useState(null);
   const [isLoading, setIsLoading] = useState(true);
   const router = useRouter();
 
+  installTokenRefresh();
+
   const fetchUser = useCallback(async () => {
     const token = localStorage.getItem('token');
     if (!token) {
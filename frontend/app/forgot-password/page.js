@@ -0,0 +1,73 @@
+"use client";
+import styles from "@/app/styles/auth.module.css";
+import { LinkButton } from "../link_button";
+import { useState } from "react";
+import { ErrorFormMessage } from "../posts/error_form";
+
+export default function ForgotPassword() {
+  const [email, setEmail] = useState("");
+  const [message, setMessage] = useState("");
+  const [errorMessage, setErrorMessage] = useState("");
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setErrorMessage("");
+    setMessage("");
+
+    try {
+      const response = await fetch("/api/password/forgot", {
+        method: "POST",
+        body: JSON.stringify({ email }),
+      });
+      const result = await response.json();
+      if (!response.ok) {
+        setErrorMessage(result.error_message);
+        return;
+      }
+      setMessage(result.message);
+    } catch (error) {
+      console.error("Error:", error);
+    }
+  };
+
+  return (
+    <main>
+      <h1 className={styles.title}>Social Network</h1>
+      <div className={styles.main_container}>
+        <form className={styles.login} onSubmit={handleSubmit}>
+          <div className={styles.header}>
+            <h1>Reset your password</h1>
+          </div>
+
+          <div className={styles.body}>
+            <div className={styles.container}>
+              <div className={styles.login}>
+                <input
+                  type="email"
+                  name="email"
+                  placeholder="email"
+                  required
+                  value={email}
+                  onChange={(e) => setEmail(e.target.value)}
+                />
+              </div>
+              <div className={styles.submit}>
+                <button className={styles.button1} type="submit">
+                  Send reset link
+                </button>
+              </div>
+              {message && <p>{message}</p>}
+            </div>
+          </div>
+
+          <div className={styles.footer}>
+            <div className={styles.container}>
+              <LinkButton Link="/login" TextContent="Back to login" />
+              <ErrorFormMessage Message={errorMessage} />
+            </div>
+          </div>
+        </form>
+      </div>
+    </main>
+  );
+}
@@ -69,11 +69,12 @@ export default function GroupChat({ groupId }) {
           throw new Error('Invalid response from server');
         });
         
-        if (!Array.isArray(data)) {
+        if (!Array.isArray(data.messages)) {
           throw new Error('Invalid data format received');
         }
 
-        setMessages(data.reverse());
+        // pages come oldest first
+        setMessages(data.messages);
         setTimeout(scrollToBottom, 100);
       } catch (err) {
         setError(err.message || 'Failed to load messages');
@@ -93,7 +94,7 @@ export default function GroupChat({ groupId }) {
         }
 
         // Create WebSocket connection
-        ws.current = new WebSocket(`ws://localhost:8080/ws/group?group_id=${groupId}`);
+        ws.current = new WebSocket('ws://localhost:8080/ws');
         
         let authSent = false;
         let authTimeout;
@@ -128,16 +129,6 @@ export default function GroupChat({ groupId }) {
 
         ws.current.onmessage = (event) => {
           try {
-            // Handle authentication success message
-            if (event.data === "authenticated") {
-              console.log('WebSocket authenticated');
-              clearTimeout(authTimeout);
-              setIsConnected(true);
-              setError(null);
-              return;
-            }
-
-            // Parse and handle regular messages
             let msg;
             try {
               msg = JSON.parse(event.data);
@@ -146,32 +137,54 @@ export default function GroupChat({ groupId }) {
               return;
             }
 
+            // Once authenticated, follow this group's topic
+            if (msg.type === 'authenticated') {
+              ws.current.send(JSON.stringify({ type: 'subscribe', topic: `group:${groupId}` }));
+              return;
+            }
+
+            if (msg.type === 'subscribed') {
+              console.log('WebSocket authenticated');
+              clearTimeout(authTimeout);
+              setIsConnected(true);
+              setError(null);
+              return;
+            }
+
             // Handle error messages
-            if (msg.error) {
+            if (msg.type === 'error' || msg.type === 'logout') {
               setError(msg.error);
-              if (msg.error.includes('auth') || msg.error.includes('token')) {
+              if (msg.code === 'unauthorized' || msg.type === 'logout') {
                 localStorage.removeItem('token');
                 window.location.href = '/login';
               }
               return;
             }
 
+            // edits, reactions and deletions replace the message we already show
+            if (msg.type === 'event' && msg.event === 'message_updated' && msg.data) {
+              const updated = msg.data;
+              setMessages(prev =>
+                prev.map(m => m.id === updated.id ? { ...updated, text: updated.deleted ? 'This message was deleted' : updated.text } : m)
+              );
+              return;
+            }
+
+            if (msg.type === 'event' && msg.event === 'message_hidden' && msg.data) {
+              setMessages(prev => prev.filter(m => m.id !== msg.data.message_id));
+              return;
+            }
+
             // Handle regular chat messages
-            if (!msg.text && !msg.Text) {
-              console.warn('Received message without text:', msg);
+            if (msg.type !== 'event' || msg.event !== 'message' || !msg.data?.text) {
               return;
             }
 
             // Add message to state
+            const chatMessage = msg.data;
             setMessages(prev => {
-              // Check for duplicates
-              const isDuplicate = prev.some(m => 
-                m.text === msg.text && 
-                m.sender === msg.sender && 
-                m.time === msg.time
-              );
-              if (isDuplicate) return prev;
-              return [...prev, msg];
+              if (prev.some(m => m.id === chatMessage.id)) return prev;
+              return [...prev, chatMessage];
             });
 
             setTimeout(scrollToBottom, 100);
@@ -245,7 +258,7 @@ export default function GroupChat({ groupId }) {
 
   const sendMessage = () => {
     if (input.trim() && ws.current && ws.current.readyState === WebSocket.OPEN) {
-      const msg = { group_id: groupId, text: input.trim() };
+      const msg = { type: 'send', topic: `group:${groupId}`, data: { content: input.trim() } };
       try {
         ws.current.send(JSON.stringify(msg));
         setInput('');
@@ -274,7 +287,7 @@ export default function GroupChat({ groupId }) {
             <div key={idx} style={{ marginBottom: 4 }}>
               <span style={{ fontWeight: 'bold' }}>{msg.sender || msg.Sender}:</span>{' '}
               {msg.text || msg.Text}{' '}
-              <span style={{ fontSize: 10, color: '#aaa' }}>{msg.time || msg.CreatedAt || ''}</span>
+              <span style={{ fontSize: 10, color: '#aaa' }}>{msg.time || msg.created_at || ''}</span>
             </div>
           ))
         )}
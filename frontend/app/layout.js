@@ -7,7 +7,7 @@ import { ThemeProvider } from "./context/ThemeContext";
 
 export default function RootLayout({ children }) {
   const pathname = usePathname();
-  const showHeader = pathname !== "/login" && pathname !== "/register";
+  const showHeader = !["/login", "/register", "/forgot-password", "/reset-password"].includes(pathname);
 
   return (
     <html lang="en">
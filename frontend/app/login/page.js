@@ -13,21 +13,62 @@ export default function Login() {
   const [errorMessage, setErrorMessage] = useState("");
   const router = useRouter();
 
+  const [challenge, setChallenge] = useState(null);
+  const [code, setCode] = useState("");
+
+  // store the tokens of a successful login and go to the home page
+  const completeLogin = (result) => {
+    if (!result.token) {
+      throw new Error("No token received");
+    }
+    // Decode the payload segment of the JWT
+    let userId = null;
+    try {
+      const segment = result.token.split(".")[1].replace(/-/g, "+").replace(/_/g, "/");
+      const payload = JSON.parse(atob(segment));
+      userId = payload.id; // Get the user ID from the decoded token
+    } catch (e) {
+      console.error("Failed to decode token", e);
+      throw new Error("Invalid token received");
+    }
+
+    if (userId !== null) {
+      localStorage.setItem('token', result.token);
+      localStorage.setItem('refreshToken', result.refresh_token);
+      localStorage.setItem('userId', userId);
+      localStorage.setItem('isLoggedIn', 'true');
+      router.push("/"); // Redirect to home page
+    } else {
+      throw new Error("Could not extract userId from token");
+    }
+  };
+
   const handleSubmit = async (e) => {
     setErrorMessage("");
 
     e.preventDefault();
 
     try {
-      const response = await fetch("/api/login", {
-        method: "POST",
-        body: JSON.stringify(formInputs),
-        credentials: "include",
-      });
+      const response = challenge
+        ? await fetch("/api/login/2fa", {
+            method: "POST",
+            body: JSON.stringify({ challenge_token: challenge, code }),
+            credentials: "include",
+          })
+        : await fetch("/api/login", {
+            method: "POST",
+            body: JSON.stringify(formInputs),
+            credentials: "include",
+          });
 
       if (!response.ok) {
         const errorData = await response.json();
-        if (response.status === 400) {
+        if (errorData.code === "invalid_challenge") {
+          // the challenge expired, start over from the password
+          setChallenge(null);
+          setCode("");
+          setErrorMessage(errorData.error_message);
+        } else if (response.status === 400) {
           console.log(errorData);
           setErrorMessage(errorData.error_message);
         } else {
@@ -39,28 +80,11 @@ export default function Login() {
         return;
       } else {
         const result = await response.json();
-        if (result.token) {
-          // Decode the base64-encoded token
-          let userId = null;
-          try {
-            const payload = JSON.parse(atob(result.token));
-            userId = payload.id; // Get the user ID from the decoded token
-          } catch (e) {
-            console.error("Failed to decode token", e);
-            throw new Error("Invalid token received");
-          }
-
-          if (userId !== null) {
-            localStorage.setItem('token', result.token);
-            localStorage.setItem('userId', userId);
-            localStorage.setItem('isLoggedIn', 'true');
-            router.push("/"); // Redirect to home page
-          } else {
-            throw new Error("Could not extract userId from token");
-          }
-        } else {
-          throw new Error("No token received");
+        if (result.two_factor_required) {
+          setChallenge(result.challenge_token);
+          return;
         }
+        completeLogin(result);
       }
     } catch (error) {
       console.error("Error:", error);
@@ -78,35 +102,52 @@ export default function Login() {
 
           <div className={styles.body}>
             <div className={styles.container}>
-              <div className={styles.login}>
-                <input
-                  id="login"
-                  type="text"
-                  name="login"
-                  placeholder="user-name/email"
-                  required
-                  value={formInputs.login}
-                  onChange={(e) =>
-                    setFormInputs({ ...formInputs, login: e.target.value })
-                  }
-                />
-              </div>
-              <div className={styles.password}>
-                <input
-                  id="password"
-                  type="password"
-                  name="password"
-                  placeholder="password"
-                  required
-                  value={formInputs.password}
-                  onChange={(e) =>
-                    setFormInputs({ ...formInputs, password: e.target.value })
-                  }
-                />
-              </div>
+              {challenge ? (
+                <div className={styles.login}>
+                  <input
+                    id="code"
+                    type="text"
+                    name="code"
+                    placeholder="authentication or recovery code"
+                    autoComplete="one-time-code"
+                    required
+                    value={code}
+                    onChange={(e) => setCode(e.target.value)}
+                  />
+                </div>
+              ) : (
+                <>
+                  <div className={styles.login}>
+                    <input
+                      id="login"
+                      type="text"
+                      name="login"
+                      placeholder="user-name/email"
+                      required
+                      value={formInputs.login}
+                      onChange={(e) =>
+                        setFormInputs({ ...formInputs, login: e.target.value })
+                      }
+                    />
+                  </div>
+                  <div className={styles.password}>
+                    <input
+                      id="password"
+                      type="password"
+                      name="password"
+                      placeholder="password"
+                      required
+                      value={formInputs.password}
+                      onChange={(e) =>
+                        setFormInputs({ ...formInputs, password: e.target.value })
+                      }
+                    />
+                  </div>
+                </>
+              )}
               <div className={styles.submit}>
                 <button className={styles.button1} type="submit">
-                  Log in
+                  {challenge ? "Verify" : "Log in"}
                 </button>
               </div>
             </div>
@@ -115,6 +156,7 @@ export default function Login() {
           <div className={styles.footer}>
             <div className={styles.container}>
               <LinkButton Link="/register" TextContent="Create New Account" />
+              <LinkButton Link="/forgot-password" TextContent="Forgot password?" />
               <ErrorFormMessage Message={errorMessage} />
             </div>
           </div>
@@ -15,6 +15,7 @@ export function LogoutButton() {
 
       if (response.ok) {
         localStorage.removeItem('token');
+        localStorage.removeItem('refreshToken');
         setUser(null);
         router.push('/login');
       } else {
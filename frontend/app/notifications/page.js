@@ -75,7 +75,7 @@ export default function NotificationsPage() {
       setNotifications(prev =>
         prev.map(notification =>
           notification.id === notificationId
-            ? { ...notification, isRead: true }
+            ? { ...notification, is_read: true }
             : notification
         )
       );
@@ -125,7 +125,7 @@ export default function NotificationsPage() {
           {notifications.map(notification => (
             <div
               key={notification.id}
-              className={`${styles.notificationItem} ${notification.isRead === 0 ? styles.unread : styles.read
+              className={`${styles.notificationItem} ${!notification.is_read ? styles.unread : styles.read
                 }`}
               onClick={() => handleNotificationClick(notification.id)}
             >
@@ -0,0 +1,73 @@
+"use client";
+import styles from "@/app/styles/auth.module.css";
+import { LinkButton } from "../link_button";
+import { useState } from "react";
+import { ErrorFormMessage } from "../posts/error_form";
+import { useRouter, useSearchParams } from "next/navigation";
+
+export default function ResetPassword() {
+  const [password, setPassword] = useState("");
+  const [errorMessage, setErrorMessage] = useState("");
+  const searchParams = useSearchParams();
+  const router = useRouter();
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setErrorMessage("");
+
+    try {
+      const response = await fetch("/api/password/reset", {
+        method: "POST",
+        body: JSON.stringify({ token: searchParams.get("token"), password }),
+      });
+      if (!response.ok) {
+        const errorData = await response.json();
+        setErrorMessage(errorData.error_message);
+        return;
+      }
+      router.push("/login");
+    } catch (error) {
+      console.error("Error:", error);
+    }
+  };
+
+  return (
+    <main>
+      <h1 className={styles.title}>Social Network</h1>
+      <div className={styles.main_container}>
+        <form className={styles.login} onSubmit={handleSubmit}>
+          <div className={styles.header}>
+            <h1>Choose a new password</h1>
+          </div>
+
+          <div className={styles.body}>
+            <div className={styles.container}>
+              <div className={styles.password}>
+                <input
+                  type="password"
+                  name="password"
+                  placeholder="new password"
+                  required
+                  value={password}
+                  onChange={(e) => setPassword(e.target.value)}
+                />
+              </div>
+              <div className={styles.submit}>
+                <button className={styles.button1} type="submit">
+                  Update password
+                </button>
+              </div>
+            </div>
+          </div>
+
+          <div className={styles.footer}>
+            <div className={styles.container}>
+              <LinkButton Link="/login" TextContent="Back to login" />
+              <ErrorFormMessage Message={errorMessage} />
+            </div>
+          </div>
+        </form>
+      </div>
+    </main>
+  );
+}
@@ -0,0 +1,57 @@
+"use client";
+import styles from "@/app/styles/auth.module.css";
+import { LinkButton } from "../link_button";
+import { useEffect, useState } from "react";
+import { ErrorFormMessage } from "../posts/error_form";
+import { useSearchParams } from "next/navigation";
+
+export default function VerifyEmail() {
+  const [message, setMessage] = useState("Verifying your email...");
+  const [errorMessage, setErrorMessage] = useState("");
+  const searchParams = useSearchParams();
+
+  useEffect(() => {
+    const verify = async () => {
+      try {
+        const response = await fetch("/api/account/verify", {
+          method: "POST",
+          body: JSON.stringify({ token: searchParams.get("token") }),
+        });
+        const result = await response.json();
+        if (!response.ok) {
+          setMessage("");
+          setErrorMessage(result.error_message);
+          return;
+        }
+        setMessage("Your email is verified, you can now use every feature.");
+      } catch (error) {
+        console.error("Error:", error);
+      }
+    };
+    verify();
+  }, [searchParams]);
+
+  return (
+    <main>
+      <h1 className={styles.title}>Social Network</h1>
+      <div className={styles.main_container}>
+        <div className={styles.login}>
+          <div className={styles.header}>
+            <h1>Email verification</h1>
+          </div>
+
+          <div className={styles.body}>
+            <div className={styles.container}>{message && <p>{message}</p>}</div>
+          </div>
+
+          <div className={styles.footer}>
+            <div className={styles.container}>
+              <LinkButton Link="/" TextContent="Continue" />
+              <ErrorFormMessage Message={errorMessage} />
+            </div>
+          </div>
+        </div>
+      </div>
+    </main>
+  );
+}